@@ -0,0 +1,580 @@
+//Filename: cmd/api/caldav.go
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"todo.imerlopez.net/internal/data"
+	"todo.imerlopez.net/internal/ical"
+	"todo.imerlopez.net/internal/validator"
+)
+
+// the CalDAV server has a principal at /dav/ that is also its calendar home,
+// and a single calendar of todo tasks whose resources are named <id>.ics
+const (
+	davRoot      = "/dav/"
+	davCalendar  = "/dav/todos/"
+	davSyncToken = "http://todo.imerlopez.net/ns/sync/"
+)
+
+// methods routed to the CalDAV server
+var davMethods = []string{"OPTIONS", "PROPFIND", "REPORT", "GET", "HEAD", "PUT", "DELETE"}
+
+// the calendar-data property is only sent when asked for by name
+var davCalendarData = xml.Name{Space: nsCalDAV, Local: "calendar-data"}
+
+// caldavHandler dispatches a CalDAV request on the resource it is for
+func (app *application) caldavHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1, 3, calendar-access")
+
+	switch p := r.URL.Path; {
+	case p == davRoot:
+		app.davRootHandler(w, r)
+	case p == davCalendar || p == strings.TrimSuffix(davCalendar, "/"):
+		app.davCalendarHandler(w, r)
+	case path.Dir(p)+"/" == davCalendar && strings.HasSuffix(p, ".ics"):
+		app.davTodoHandler(w, r, path.Base(p))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// caldavRedirectHandler points clients looking for /.well-known/caldav at the server (RFC 6764)
+func (app *application) caldavRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, davRoot, http.StatusMovedPermanently)
+}
+
+// the principal and calendar home
+func (app *application) davRootHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("Allow", "OPTIONS, PROPFIND")
+	case "PROPFIND":
+		var body davPropfind
+		err := readDAVBody(r, &body)
+		if err != nil {
+			http.Error(w, "malformed PROPFIND body", http.StatusBadRequest)
+			return
+		}
+
+		ms := newMultistatus(w)
+		ms.props(davRoot, app.davRootProps(), wantedProps(body.AllProp, body.Prop))
+
+		if r.Header.Get("Depth") != "0" {
+			props, err := app.davCalendarProps()
+			if err != nil {
+				app.logError(r, err)
+				panic(http.ErrAbortHandler)
+			}
+			ms.props(davCalendar, props, wantedProps(body.AllProp, body.Prop))
+		}
+
+		ms.end("")
+	default:
+		w.Header().Set("Allow", "OPTIONS, PROPFIND")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// the calendar collection
+func (app *application) davCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT")
+	case "PROPFIND":
+		app.davPropfindCalendar(w, r)
+	case "REPORT":
+		app.davReport(w, r)
+	default:
+		w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// wantedProps() returns nil, meaning every property, for allprop and empty requests
+func wantedProps(allProp *struct{}, prop davPropNames) []xml.Name {
+	if allProp != nil || len(prop) == 0 {
+		return nil
+	}
+	return prop
+}
+
+func (app *application) davRootProps() davProps {
+	return davProps{
+		{Space: nsDAV, Local: "resourcetype"}:           davXML("<d:collection/><d:principal/>"),
+		{Space: nsDAV, Local: "displayname"}:            davText("Todo API"),
+		{Space: nsDAV, Local: "current-user-principal"}: davHref(davRoot),
+		{Space: nsDAV, Local: "principal-URL"}:          davHref(davRoot),
+		{Space: nsCalDAV, Local: "calendar-home-set"}:   davHref(davRoot),
+	}
+}
+
+func (app *application) davCalendarProps() (davProps, error) {
+	latest, err := app.models.Events.Latest()
+	if err != nil {
+		return nil, err
+	}
+
+	token := davSyncToken + strconv.FormatInt(latest, 10)
+
+	return davProps{
+		{Space: nsDAV, Local: "resourcetype"}:                        davXML("<d:collection/><c:calendar/>"),
+		{Space: nsDAV, Local: "displayname"}:                         davText("Todos"),
+		{Space: nsDAV, Local: "current-user-principal"}:              davHref(davRoot),
+		{Space: nsDAV, Local: "sync-token"}:                          davText(token),
+		{Space: nsCS, Local: "getctag"}:                              davText(token),
+		{Space: nsCalDAV, Local: "supported-calendar-component-set"}: davXML(`<c:comp name="VTODO"/>`),
+		{Space: nsDAV, Local: "supported-report-set"}: davXML(
+			"<d:supported-report><d:report><c:calendar-query/></d:report></d:supported-report>" +
+				"<d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report>" +
+				"<d:supported-report><d:report><d:sync-collection/></d:report></d:supported-report>"),
+		{Space: nsDAV, Local: "current-user-privilege-set"}: davXML(
+			"<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>" +
+				"<d:privilege><d:write-content/></d:privilege><d:privilege><d:bind/></d:privilege>" +
+				"<d:privilege><d:unbind/></d:privilege>"),
+	}, nil
+}
+
+// todoHref() is the path of a todo task's resource, named after its public id
+func todoHref(publicID string) string {
+	return davCalendar + publicID + ".ics"
+}
+
+// davTodoProps() describes a todo task's resource
+func davTodoProps(todo *data.Todo) (davProps, error) {
+	etag, err := etagFor(todo)
+	if err != nil {
+		return nil, err
+	}
+
+	return davProps{
+		{Space: nsDAV, Local: "resourcetype"}:    davXML(""),
+		{Space: nsDAV, Local: "getetag"}:         davText(etag),
+		{Space: nsDAV, Local: "getcontenttype"}:  davText("text/calendar; charset=utf-8; component=vtodo"),
+		{Space: nsDAV, Local: "getlastmodified"}: davText(todo.UpdatedAt.UTC().Format(http.TimeFormat)),
+		davCalendarData: func() string {
+			var b strings.Builder
+			cal := newCalendar()
+			cal.Components = append(cal.Components, vtodo(todo))
+			ical.NewEncoder(&b).Encode(cal)
+			return davText(b.String())()
+		},
+	}, nil
+}
+
+// davPropfindCalendar answers a PROPFIND on the calendar, with Depth 1 each todo
+// task is listed as it is read from the database
+func (app *application) davPropfindCalendar(w http.ResponseWriter, r *http.Request) {
+	var body davPropfind
+	err := readDAVBody(r, &body)
+	if err != nil {
+		http.Error(w, "malformed PROPFIND body", http.StatusBadRequest)
+		return
+	}
+
+	props, err := app.davCalendarProps()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	wanted := wantedProps(body.AllProp, body.Prop)
+
+	ms := newMultistatus(w)
+	ms.props(davCalendar, props, wanted)
+
+	if r.Header.Get("Depth") != "0" {
+		app.davEachTodo(r, func(todo *data.Todo) bool { return true }, func(todo *data.Todo) error {
+			props, err := davTodoProps(todo)
+			if err != nil {
+				return err
+			}
+			return ms.props(todoHref(todo.PublicID), props, wanted, davCalendarData)
+		})
+	}
+
+	ms.end("")
+}
+
+// davEachTodo() passes the todo tasks that match to fn. The multistatus has been
+// started, so a failure can only abort the response
+func (app *application) davEachTodo(r *http.Request, match func(*data.Todo) bool, fn func(*data.Todo) error) {
+	filters := data.Filters{Sort: "id", SortList: data.TodoSortList}
+
+	err := app.models.Todos.Export(r.Context(), data.TodoSearch{}, filters, func(todo *data.Todo) error {
+		if !match(todo) {
+			return nil
+		}
+		return fn(todo)
+	})
+
+	if err != nil {
+		if !errors.Is(err, r.Context().Err()) {
+			app.logError(r, err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// davReport answers the calendar-query, calendar-multiget and sync-collection reports
+func (app *application) davReport(w http.ResponseWriter, r *http.Request) {
+	var body davReport
+	err := readDAVBody(r, &body)
+	if err != nil {
+		http.Error(w, "malformed REPORT body", http.StatusBadRequest)
+		return
+	}
+
+	wanted := wantedProps(body.AllProp, body.Prop)
+
+	switch body.XMLName {
+	case xml.Name{Space: nsCalDAV, Local: "calendar-query"}:
+		match := calendarQueryMatcher(body.Filter)
+
+		ms := newMultistatus(w)
+		app.davEachTodo(r, match, func(todo *data.Todo) error {
+			props, err := davTodoProps(todo)
+			if err != nil {
+				return err
+			}
+			return ms.props(todoHref(todo.PublicID), props, wanted, davCalendarData)
+		})
+		ms.end("")
+
+	case xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}:
+		ms := newMultistatus(w)
+		for _, href := range body.Hrefs {
+			todo, err := app.davLookup(path.Base(href))
+			if err != nil {
+				if !errors.Is(err, data.ErrRecordNotFound) {
+					app.logError(r, err)
+					panic(http.ErrAbortHandler)
+				}
+				ms.status(href, http.StatusNotFound)
+				continue
+			}
+
+			props, err := davTodoProps(todo)
+			if err != nil {
+				app.logError(r, err)
+				panic(http.ErrAbortHandler)
+			}
+			ms.props(href, props, wanted, davCalendarData)
+		}
+		ms.end("")
+
+	case xml.Name{Space: nsDAV, Local: "sync-collection"}:
+		app.davSyncCollection(w, r, body.SyncToken, wanted)
+
+	default:
+		davPreconditionFailed(w, http.StatusForbidden, xml.Name{Space: nsDAV, Local: "supported-report"})
+	}
+}
+
+// calendarQueryMatcher() selects the todo tasks of a calendar-query filter. Only
+// VTODO components are stored and the COMPLETED is-not-defined filter that
+// clients use to fetch open tasks is applied, other filters match everything
+func calendarQueryMatcher(filter *davCompFilter) func(*data.Todo) bool {
+	if filter == nil || len(filter.CompFilters) == 0 {
+		return func(*data.Todo) bool { return true }
+	}
+
+	openOnly := false
+	for _, comp := range filter.CompFilters {
+		if !strings.EqualFold(comp.Name, "VTODO") {
+			return func(*data.Todo) bool { return false }
+		}
+		for _, prop := range comp.PropFilters {
+			if strings.EqualFold(prop.Name, "COMPLETED") && prop.IsNotDefined != nil {
+				openOnly = true
+			}
+		}
+	}
+
+	return func(todo *data.Todo) bool {
+		return !openOnly || !todo.Completed
+	}
+}
+
+// davSyncCollection answers a sync-collection report (RFC 6578) from the change
+// log of todo tasks, the sync token is the id of the last change the client has seen
+func (app *application) davSyncCollection(w http.ResponseWriter, r *http.Request, token string, wanted []xml.Name) {
+	latest, err := app.models.Events.Latest()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//an empty token asks for every resource
+	if token == "" {
+		ms := newMultistatus(w)
+		app.davEachTodo(r, func(*data.Todo) bool { return true }, func(todo *data.Todo) error {
+			props, err := davTodoProps(todo)
+			if err != nil {
+				return err
+			}
+			return ms.props(todoHref(todo.PublicID), props, wanted, davCalendarData)
+		})
+		ms.end(davSyncToken + strconv.FormatInt(latest, 10))
+		return
+	}
+
+	since, err := strconv.ParseInt(strings.TrimPrefix(token, davSyncToken), 10, 64)
+	if err != nil || !strings.HasPrefix(token, davSyncToken) || since < 0 || since > latest {
+		davPreconditionFailed(w, http.StatusForbidden, xml.Name{Space: nsDAV, Local: "valid-sync-token"})
+		return
+	}
+
+	//keep the last change of each todo task, in the order they were last changed
+	changes := make(map[string]*data.Event)
+	order := []string{}
+
+	for since < latest {
+		events, err := app.models.Events.GetSince(since, 500)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if len(events) == 0 {
+			break
+		}
+
+		for _, event := range events {
+			//changes recorded before tasks had public ids name them by number
+			name := event.TodoPublicID
+			if name == "" {
+				name = strconv.FormatInt(event.TodoID, 10)
+			}
+
+			if _, ok := changes[name]; !ok {
+				order = append(order, name)
+			}
+			changes[name] = event
+			since = event.ID
+		}
+	}
+
+	ms := newMultistatus(w)
+	for _, id := range order {
+		//the joined todo is the task as it is now, nil once it has been deleted
+		todo := changes[id].Todo
+		if todo == nil {
+			ms.status(todoHref(id), http.StatusNotFound)
+			continue
+		}
+
+		props, err := davTodoProps(todo)
+		if err != nil {
+			app.logError(r, err)
+			panic(http.ErrAbortHandler)
+		}
+		ms.props(todoHref(id), props, wanted, davCalendarData)
+	}
+	ms.end(davSyncToken + strconv.FormatInt(since, 10))
+}
+
+// davLookup() finds the todo task of a resource name such as
+// 01J9Z3V5G7Q8R2M4N6P8T0W2Y4.ics, or 12.ics while -numeric-ids is on
+func (app *application) davLookup(name string) (*data.Todo, error) {
+	if publicID, ok := data.ParsePublicID(strings.TrimSuffix(name, ".ics")); ok {
+		return app.models.Todos.GetByPublicID(publicID)
+	}
+
+	id, err := strconv.ParseInt(strings.TrimSuffix(name, ".ics"), 10, 64)
+	if err != nil || id < 1 || !app.config.todos.numericIDs {
+		return nil, data.ErrRecordNotFound
+	}
+
+	return app.models.Todos.Get(id)
+}
+
+// a todo task's resource
+func (app *application) davTodoHandler(w http.ResponseWriter, r *http.Request, name string) {
+	todo, err := app.davLookup(name)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	allow := "OPTIONS, PROPFIND, GET, HEAD, PUT, DELETE"
+
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("Allow", allow)
+	case "PUT":
+		app.davPutTodo(w, r, name, todo)
+	case "GET", "HEAD", "PROPFIND", "DELETE":
+		if todo == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case "DELETE":
+			app.davDeleteTodo(w, r, todo)
+		case "PROPFIND":
+			var body davPropfind
+			err := readDAVBody(r, &body)
+			if err != nil {
+				http.Error(w, "malformed PROPFIND body", http.StatusBadRequest)
+				return
+			}
+
+			props, err := davTodoProps(todo)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+
+			ms := newMultistatus(w)
+			ms.props(todoHref(todo.PublicID), props, wantedProps(body.AllProp, body.Prop), davCalendarData)
+			ms.end("")
+		default:
+			app.davGetTodo(w, r, todo)
+		}
+	default:
+		w.Header().Set("Allow", allow)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (app *application) davGetTodo(w http.ResponseWriter, r *http.Request, todo *data.Todo) {
+	etag, err := etagFor(todo)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.notModified(w, r, etag, todo.UpdatedAt) {
+		return
+	}
+
+	cal := newCalendar()
+	cal.Components = append(cal.Components, vtodo(todo))
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8; component=vtodo")
+	if r.Method == "HEAD" {
+		return
+	}
+
+	err = ical.NewEncoder(w).Encode(cal)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// davPreconditions() applies If-Match and If-None-Match to a resource, todo is nil
+// when it doesn't exist. It reports false when a 412 has been written
+func davPreconditions(w http.ResponseWriter, r *http.Request, todo *data.Todo) bool {
+	etag := ""
+	if todo != nil {
+		etag, _ = etagFor(todo)
+	}
+
+	if im := r.Header.Get("If-Match"); im != "" && (todo == nil || !etagMatches(im, etag)) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" && todo != nil && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return false
+	}
+
+	return true
+}
+
+// davPutTodo creates or replaces a todo task from a calendar holding one VTODO.
+// New tasks keep the name the client chose, letter case included, when it is a
+// UUID, otherwise they get a ULID and the Location header says where they
+// were created
+func (app *application) davPutTodo(w http.ResponseWriter, r *http.Request, name string, todo *data.Todo) {
+	if !davPreconditions(w, r, todo) {
+		return
+	}
+
+	cal, err := ical.Parse(http.MaxBytesReader(w, r.Body, 1_048_576))
+	if err != nil || cal.Name != "VCALENDAR" || len(cal.Find("VTODO")) != 1 {
+		davPreconditionFailed(w, http.StatusForbidden, xml.Name{Space: nsCalDAV, Local: "valid-calendar-data"})
+		return
+	}
+
+	created := todo == nil
+	if created {
+		todo = &data.Todo{}
+
+		//most clients name new resources with a UUID, which can be kept. It is
+		//stored as written, Apple Reminders uses upper case and wouldn't
+		//recognise its resource under a lower case href
+		id := strings.TrimSuffix(name, ".ics")
+		if _, ok := data.ParsePublicID(id); ok && app.config.todos.clientIDs {
+			todo.PublicID = id
+		}
+	}
+
+	previous := todo.Description
+	readVTODO(cal.Find("VTODO")[0], todo)
+
+	//clients often leave the description out, which the API requires
+	if strings.TrimSpace(todo.Description) == "" {
+		todo.Description = previous
+		if created {
+			todo.Description = "Created with CalDAV"
+		}
+	}
+
+	v := validator.New()
+	if data.ValidateTodo(v, todo); !v.Valid() {
+		davPreconditionFailed(w, http.StatusForbidden, xml.Name{Space: nsCalDAV, Local: "valid-calendar-object-resource"})
+		return
+	}
+
+	if created {
+		err = app.models.Todos.Insert(todo)
+	} else {
+		err = app.models.Todos.Update(todo)
+	}
+
+	switch {
+	case errors.Is(err, data.ErrEditConflict), errors.Is(err, data.ErrDuplicatePublicID):
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if created {
+		w.Header().Set("Location", todoHref(todo.PublicID))
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	etag, err := etagFor(todo)
+	if err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) davDeleteTodo(w http.ResponseWriter, r *http.Request, todo *data.Todo) {
+	if !davPreconditions(w, r, todo) {
+		return
+	}
+
+	err := app.models.Todos.Delete(todo.ID)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		http.NotFound(w, r)
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
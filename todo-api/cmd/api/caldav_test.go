@@ -0,0 +1,52 @@
+//Filename: cmd/api/caldav_test.go
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCalDAVKeepsResourceName(t *testing.T) {
+	app := newTestApplication(t)
+
+	//Apple Reminders names new resources with upper case UUIDs
+	href := "/dav/todos/6B29FC40-CA47-1067-B31D-00DD010662DA.ics"
+
+	calendar := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//test//EN",
+		"BEGIN:VTODO",
+		"UID:6B29FC40-CA47-1067-B31D-00DD010662DA",
+		"SUMMARY:errands",
+		"END:VTODO",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+
+	res := app.request(t, http.MethodPut, href, calendar, map[string]string{"Content-Type": "text/calendar"})
+	if res.status != http.StatusCreated {
+		t.Fatalf("PUT status %d, want %d: %s", res.status, http.StatusCreated, res.body)
+	}
+	if location := res.headers.Get("Location"); location != href {
+		t.Errorf("Location %q, want %q", location, href)
+	}
+
+	res = app.request(t, "PROPFIND", "/dav/todos/", "", map[string]string{"Depth": "1"})
+	if res.status != http.StatusMultiStatus {
+		t.Fatalf("PROPFIND status %d, want %d: %s", res.status, http.StatusMultiStatus, res.body)
+	}
+	if !strings.Contains(string(res.body), "<d:href>"+href+"</d:href>") {
+		t.Errorf("PROPFIND doesn't list %s: %s", href, res.body)
+	}
+
+	//the REST API finds the task in either case
+	for _, id := range []string{"6B29FC40-CA47-1067-B31D-00DD010662DA", "6b29fc40-ca47-1067-b31d-00dd010662da"} {
+		res = app.request(t, http.MethodGet, "/v1/todos/"+id, "", nil)
+		if res.status != http.StatusOK {
+			t.Errorf("GET %s status %d, want %d", id, res.status, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+//Filename: cmd/api/compress.go
+
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// the content codings responses can be compressed with, in order of preference
+// when the client accepts several equally
+var compressors = []struct {
+	name   string
+	writer func(w io.Writer) compressor
+}{
+	{"zstd", newZstdCompressor},
+	{"gzip", newGzipCompressor},
+	{"deflate", newFlateCompressor},
+}
+
+// a compressor is returned to its pool by Close()
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+var (
+	gzipPool = sync.Pool{New: func() interface{} {
+		zw, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return zw
+	}}
+	flatePool = sync.Pool{New: func() interface{} {
+		fw, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return fw
+	}}
+	zstdPool = sync.Pool{New: func() interface{} {
+		//one goroutine per response, the pool provides the concurrency
+		zw, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return zw
+	}}
+)
+
+type gzipCompressor struct{ *gzip.Writer }
+
+func newGzipCompressor(w io.Writer) compressor {
+	zw := gzipPool.Get().(*gzip.Writer)
+	zw.Reset(w)
+	return gzipCompressor{zw}
+}
+
+func (c gzipCompressor) Close() error {
+	err := c.Writer.Close()
+	gzipPool.Put(c.Writer)
+	return err
+}
+
+type flateCompressor struct{ *flate.Writer }
+
+func newFlateCompressor(w io.Writer) compressor {
+	fw := flatePool.Get().(*flate.Writer)
+	fw.Reset(w)
+	return flateCompressor{fw}
+}
+
+func (c flateCompressor) Close() error {
+	err := c.Writer.Close()
+	flatePool.Put(c.Writer)
+	return err
+}
+
+type zstdCompressor struct{ *zstd.Encoder }
+
+func newZstdCompressor(w io.Writer) compressor {
+	zw := zstdPool.Get().(*zstd.Encoder)
+	zw.Reset(w)
+	return zstdCompressor{zw}
+}
+
+func (c zstdCompressor) Close() error {
+	err := c.Encoder.Close()
+	zstdPool.Put(c.Encoder)
+	return err
+}
+
+// acceptedEncodings() reads the qualities of an Accept-Encoding header, a
+// coding with q=0 is refused
+func acceptedEncodings(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		if key, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(key) == "q" {
+			q, _ = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		}
+
+		accepted[coding] = q
+	}
+
+	return accepted
+}
+
+// encodingQuality() returns the quality a client gives to coding, * stands for
+// every coding not listed
+func encodingQuality(accepted map[string]float64, coding string) float64 {
+	if q, ok := accepted[coding]; ok {
+		return q
+	}
+	return accepted["*"]
+}
+
+// compressible() reports whether a response of this content type is worth
+// compressing. Event streams are flushed an event at a time and are left alone
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/x-ndjson", "application/xml",
+		"application/msgpack", "application/x-msgpack", "application/javascript":
+		return true
+	}
+
+	return false
+}
+
+// compress() compresses responses with the coding the client prefers. Small
+// responses are sent as they are, compressing them costs more than it saves
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//websocket handshakes hijack the connection
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		accepted := acceptedEncodings(r.Header.Get("Accept-Encoding"))
+
+		coding, bestQ := "", 0.0
+		for _, c := range compressors {
+			if q := encodingQuality(accepted, c.name); q > bestQ {
+				coding, bestQ = c.name, q
+			}
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: w,
+			request:        r,
+			coding:         coding,
+			minSize:        app.config.compression.minSize,
+		}
+
+		next.ServeHTTP(cw, r)
+
+		//a handler that panics to abort the response mustn't get a proper
+		//end of stream, so this is not deferred
+		err := cw.close()
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+}
+
+// compressWriter holds back the start of a response until it knows whether the
+// response is big enough to compress
+type compressWriter struct {
+	http.ResponseWriter
+	request *http.Request
+	coding  string
+	minSize int
+
+	status  int
+	buf     []byte
+	started bool
+	enc     compressor
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	//informational responses go straight through
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.started {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.minSize {
+			return len(b), nil
+		}
+
+		err := cw.start(true)
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// start() sends the status and headers followed by what has been buffered,
+// compressed when it is worth it and allowed
+func (cw *compressWriter) start(large bool) error {
+	cw.started = true
+
+	h := cw.Header()
+
+	if compressible(h.Get("Content-Type")) {
+		addVary(h, "Accept-Encoding")
+	}
+
+	ok := large && cw.coding != "" && compressible(h.Get("Content-Type")) &&
+		h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+		cw.request.Method != http.MethodHead &&
+		cw.status != http.StatusNoContent && cw.status != http.StatusNotModified &&
+		cw.status != http.StatusPartialContent
+
+	if ok {
+		h.Set("Content-Encoding", cw.coding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+
+		//the compressed bytes are a different representation
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		for _, c := range compressors {
+			if c.name == cw.coding {
+				cw.enc = c.writer(cw.ResponseWriter)
+			}
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+
+	return err
+}
+
+// Flush() sends what has been written so far, a response flushed before it
+// reached the minimum size is not compressed
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.started {
+		if cw.start(false) != nil {
+			return
+		}
+	}
+
+	if cw.enc != nil {
+		if cw.enc.Flush() != nil {
+			return
+		}
+	}
+
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// close() finishes the response once the handler has returned
+func (cw *compressWriter) close() error {
+	if !cw.started {
+		//nothing was written, let the server send its default response
+		if cw.status == 0 {
+			return nil
+		}
+
+		return cw.start(false)
+	}
+
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+
+	return nil
+}
+
+// Unwrap() lets http.ResponseController reach the underlying writer
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,125 @@
+//Filename: cmd/api/conditional.go
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// etagFor() computes a strong ETag from the JSON representation of data. It is
+// the ETag of JSON responses and CalDAV resources, see representationETag()
+func etagFor(data interface{}) (string, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(js)
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// representationETag() turns the ETag of the JSON response into the ETag of the
+// response enc writes. Each media type needs its own, a cached XML copy must
+// not be confirmed by the ETag of the JSON one
+func representationETag(etag string, enc *responseEncoder) string {
+	if enc.mediaType == "application/json" {
+		return etag
+	}
+
+	return strings.TrimSuffix(etag, `"`) + "-" + enc.mediaType + `"`
+}
+
+// anyRepresentation() strips the media types representationETag() adds from the
+// ETags in an If-Match or If-None-Match header
+func anyRepresentation(header string) string {
+	candidates := strings.Split(header, ",")
+	for i, candidate := range candidates {
+		if before, _, found := strings.Cut(candidate, "-"); found {
+			candidates[i] = before + `"`
+		}
+	}
+
+	return strings.Join(candidates, ",")
+}
+
+// notModified() sets the validator headers for the response and reports whether the
+// client's cached copy is still current, in which case a 304 has been written.
+// A zero lastModified means the resource has no Last-Modified date
+func (app *application) notModified(w http.ResponseWriter, r *http.Request, etag string, lastModified time.Time) bool {
+
+	w.Header().Set("ETag", etag)
+	//caches may keep the response but must check back before using it
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	//If-None-Match takes precedence over If-Modified-Since
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else {
+		ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		if err != nil || lastModified.IsZero() {
+			return false
+		}
+
+		//Last-Modified only has second precision
+		if lastModified.Truncate(time.Second).After(ims) {
+			return false
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+
+	return true
+}
+
+// etagMatches() checks an If-None-Match header against the current ETag using
+// the weak comparison the header calls for
+func etagMatches(header string, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// preconditionsHold() checks If-Match and If-None-Match before a todo task is
+// written, etag is "" when there is no task yet. Compression only weakens the
+// ETag of the same JSON, so a weak ETag is as good as its strong one here, and
+// the task is the same whichever media type the client read it in
+func preconditionsHold(r *http.Request, etag string) bool {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if etag == "" || !etagMatches(anyRepresentation(im), etag) {
+			return false
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etag != "" && etagMatches(anyRepresentation(inm), etag) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,242 @@
+//Filename: cmd/api/davxml.go
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// XML namespaces of WebDAV, CalDAV and the calendarserver.org extensions
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+	nsCS     = "http://calendarserver.org/ns/"
+)
+
+// prefixes used when writing elements of the known namespaces
+var davPrefixes = map[string]string{
+	nsDAV:    "d",
+	nsCalDAV: "c",
+	nsCS:     "cs",
+}
+
+// davPropNames collects the names of the elements inside a DAV:prop element
+type davPropNames []xml.Name
+
+func (p *davPropNames) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			*p = append(*p, t.Name)
+			err = d.Skip()
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// davPropfind is the body of a PROPFIND request, an empty body asks for all properties
+type davPropfind struct {
+	XMLName xml.Name     `xml:"DAV: propfind"`
+	AllProp *struct{}    `xml:"DAV: allprop"`
+	Prop    davPropNames `xml:"DAV: prop"`
+}
+
+// davCompFilter is a comp-filter of a calendar-query, only the component names
+// and is-not-defined property filters are looked at
+type davCompFilter struct {
+	Name        string          `xml:"name,attr"`
+	CompFilters []davCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	PropFilters []struct {
+		Name         string    `xml:"name,attr"`
+		IsNotDefined *struct{} `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	} `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+}
+
+// davReport is the body of the calendar-query, calendar-multiget and sync-collection reports
+type davReport struct {
+	XMLName   xml.Name
+	AllProp   *struct{}      `xml:"DAV: allprop"`
+	Prop      davPropNames   `xml:"DAV: prop"`
+	Hrefs     []string       `xml:"DAV: href"`
+	SyncToken string         `xml:"DAV: sync-token"`
+	Filter    *davCompFilter `xml:"urn:ietf:params:xml:ns:caldav filter>comp-filter"`
+}
+
+// readDAVBody() decodes an XML request body into dst, an empty body leaves dst unchanged
+func readDAVBody(r *http.Request, dst interface{}) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1_048_576))
+	if err != nil {
+		return err
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	return xml.Unmarshal(body, dst)
+}
+
+// a davProp returns the XML content of a property, it is called only when the property is wanted
+type davProp func() string
+
+// davProps are the properties of a resource
+type davProps map[xml.Name]davProp
+
+// davText() is a property with a text value
+func davText(value string) davProp {
+	return func() string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(value))
+		return b.String()
+	}
+}
+
+// davXML() is a property with XML content
+func davXML(content string) davProp {
+	return func() string {
+		return content
+	}
+}
+
+// davHref() is a property holding a single href
+func davHref(href string) davProp {
+	return davXML("<d:href>" + davText(href)() + "</d:href>")
+}
+
+// multistatus writes a 207 Multi-Status response one resource at a time
+type multistatus struct {
+	w *bufio.Writer
+}
+
+func newMultistatus(w http.ResponseWriter) *multistatus {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+
+	ms := &multistatus{w: bufio.NewWriter(w)}
+	ms.w.WriteString(xml.Header)
+	ms.w.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">`)
+
+	return ms
+}
+
+// props() writes a resource with the wanted properties it has, the ones it
+// doesn't have are reported as not found. A nil wanted list asks for every
+// property except those in hidden
+func (ms *multistatus) props(href string, props davProps, wanted []xml.Name, hidden ...xml.Name) error {
+	if wanted == nil {
+	next:
+		for name := range props {
+			for _, h := range hidden {
+				if name == h {
+					continue next
+				}
+			}
+			wanted = append(wanted, name)
+		}
+	}
+
+	var found, missing bytes.Buffer
+	for _, name := range wanted {
+		if prop, ok := props[name]; ok {
+			writeDAVElement(&found, name, prop())
+		} else {
+			writeDAVElement(&missing, name, "")
+		}
+	}
+
+	ms.w.WriteString("<d:response>")
+	writeDAVElement(ms.w, xml.Name{Space: nsDAV, Local: "href"}, davText(href)())
+
+	if found.Len() > 0 || missing.Len() == 0 {
+		fmt.Fprintf(ms.w, "<d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>", found.String())
+	}
+	if missing.Len() > 0 {
+		fmt.Fprintf(ms.w, "<d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>", missing.String())
+	}
+
+	ms.w.WriteString("</d:response>")
+
+	return ms.flushIfFull()
+}
+
+// status() writes a resource with only a status, e.g. one removed since the last sync
+func (ms *multistatus) status(href string, status int) error {
+	fmt.Fprintf(ms.w, "<d:response><d:href>%s</d:href><d:status>HTTP/1.1 %d %s</d:status></d:response>",
+		davText(href)(), status, http.StatusText(status))
+
+	return ms.flushIfFull()
+}
+
+// end() closes the response, a sync-collection report passes its new sync token
+func (ms *multistatus) end(syncToken string) error {
+	if syncToken != "" {
+		writeDAVElement(ms.w, xml.Name{Space: nsDAV, Local: "sync-token"}, davText(syncToken)())
+	}
+
+	ms.w.WriteString("</d:multistatus>\n")
+
+	return ms.w.Flush()
+}
+
+// flushIfFull() sends what has been written once the buffer has filled up
+func (ms *multistatus) flushIfFull() error {
+	if ms.w.Buffered() > 32*1024 {
+		return ms.w.Flush()
+	}
+	return nil
+}
+
+// writeDAVElement() writes an element with content, elements of namespaces without
+// a prefix declare their namespace themselves
+func writeDAVElement(w io.Writer, name xml.Name, content string) {
+	var local bytes.Buffer
+	xml.EscapeText(&local, []byte(name.Local))
+
+	tag := local.String()
+	open := tag
+
+	if prefix, ok := davPrefixes[name.Space]; ok {
+		tag = prefix + ":" + tag
+		open = tag
+	} else if name.Space != "" {
+		var space bytes.Buffer
+		xml.EscapeText(&space, []byte(name.Space))
+		tag = "x:" + tag
+		open = tag + ` xmlns:x="` + space.String() + `"`
+	}
+
+	if content == "" {
+		fmt.Fprintf(w, "<%s/>", open)
+		return
+	}
+
+	fmt.Fprintf(w, "<%s>%s</%s>", open, content, tag)
+}
+
+// davPreconditionFailed() writes a DAV:error naming the precondition the request broke
+func davPreconditionFailed(w http.ResponseWriter, status int, condition xml.Name) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<d:error xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">`)
+	writeDAVElement(&b, condition, "")
+	b.WriteString("</d:error>\n")
+
+	w.Write(b.Bytes())
+}
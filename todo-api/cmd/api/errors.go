@@ -19,7 +19,7 @@ func (app *application) logError(r *http.Request, err error) {
 func (app *application) errorRepsonse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
 	//create json response
 	env := envelope{"error": message}
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeResponse(w, r, status, env, nil)
 
 	if err != nil {
 		app.logError(r, err)
@@ -75,9 +75,43 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorRepsonse(w, r, http.StatusConflict, message)
 }
 
+// the todo task isn't in the state If-Match or If-None-Match asked for
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the todo task has changed or already exists, fetch it again before writing"
+	app.errorRepsonse(w, r, http.StatusPreconditionFailed, message)
+}
+
 // Rate Limit Errors
 func (app *application) rateLimitExceedeResponse(w http.ResponseWriter, r *http.Request) {
 	//create msg
 	message := "rate limit exceeded"
 	app.errorRepsonse(w, r, http.StatusTooManyRequests, message)
 }
+
+// the feed token is missing or wrong
+func (app *application) invalidFeedTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or missing feed token"
+	app.errorRepsonse(w, r, http.StatusUnauthorized, message)
+}
+
+// none of the response media types is acceptable to the client
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource can't be returned in any of the accepted media types, accept application/json, application/msgpack, application/xml or text/csv for lists"
+	app.errorRepsonse(w, r, http.StatusNotAcceptable, message)
+}
+
+// the request body has a Content-Type there's no decoder for
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorRepsonse(w, r, http.StatusUnsupportedMediaType, err.Error())
+}
+
+// a test operation of a JSON Patch didn't match the stored todo task
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a test operation of the patch failed, the todo task is not what the patch expects"
+	app.errorRepsonse(w, r, http.StatusConflict, message)
+}
+
+// the patch is well formed but doesn't fit the todo task
+func (app *application) patchNotAppliedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorRepsonse(w, r, http.StatusUnprocessableEntity, err.Error())
+}
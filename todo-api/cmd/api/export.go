@@ -0,0 +1,232 @@
+//Filename: cmd/api/export.go
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"todo.imerlopez.net/internal/data"
+	"todo.imerlopez.net/internal/validator"
+)
+
+// a todoEncoder writes exported todo tasks in one of the export formats
+type todoEncoder interface {
+	begin() error
+	encode(todo *data.Todo) error
+	end() error
+}
+
+// how long a client may take to accept each row of an export
+const exportWriteTimeout = 10 * time.Second
+
+// export formats and their content types
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"json":   "application/json",
+	"ndjson": "application/x-ndjson",
+}
+
+// exportTodosHandler streams every todo task matching the list filters as a download.
+// Rows go to the client as they are read from the database, nothing is buffered
+func (app *application) exportTodosHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	search := app.readTodoSearch(qs, v)
+
+	filters := data.Filters{
+		Sort:     app.readString(qs, "sort", "id"),
+		SortList: data.TodoSortList,
+	}
+	v.Check(validator.In(filters.Sort, filters.SortList...), "sort", "invalid sort value")
+
+	format := app.readString(qs, "format", "json")
+	_, ok := exportContentTypes[format]
+	v.Check(ok, "format", "must be csv, json or ndjson")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var enc todoEncoder
+	switch format {
+	case "csv":
+		enc = &csvEncoder{w: csv.NewWriter(w)}
+	case "json":
+		enc = &jsonArrayEncoder{w: w}
+	case "ndjson":
+		enc = &ndjsonEncoder{enc: json.NewEncoder(w)}
+	}
+
+	filename := fmt.Sprintf("todos-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", exportContentTypes[format])
+	headers.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	app.writeTodos(w, r, search, filters, enc, headers)
+}
+
+// writeTodos() streams the todo tasks matching search to enc. The response starts
+// with the first row, so a query that fails right away still gets a proper error response
+func (app *application) writeTodos(w http.ResponseWriter, r *http.Request, search data.TodoSearch, filters data.Filters, enc todoEncoder, headers http.Header) {
+	started := false
+	start := func() error {
+		for key, value := range headers {
+			w.Header()[key] = value
+		}
+		w.WriteHeader(http.StatusOK)
+
+		started = true
+
+		return enc.begin()
+	}
+
+	//a large export can take longer than the server's write timeout, instead
+	//every row gets its own deadline so that a client that stops reading
+	//doesn't hold the rows and their connection open
+	rc := http.NewResponseController(w)
+
+	err := app.models.Todos.Export(r.Context(), search, filters, func(todo *data.Todo) error {
+		err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		return enc.encode(todo)
+	})
+
+	if err == nil && !started {
+		err = start()
+	}
+
+	if err == nil {
+		err = enc.end()
+	}
+
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		//the status has been sent, abort so the client sees a truncated response
+		//rather than a complete looking file
+		if !errors.Is(err, r.Context().Err()) {
+			app.logError(r, err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// csvEncoder writes a header row followed by a row per todo task. Exports are
+// opened in spreadsheets, text that would start a formula is escaped
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) begin() error {
+	return e.w.Write([]string{"id", "created_at", "title", "description", "completed", "updated_at", "completed_at"})
+}
+
+func (e *csvEncoder) encode(todo *data.Todo) error {
+	completedAt := ""
+	if todo.CompletedAt != nil {
+		completedAt = todo.CompletedAt.Format(time.RFC3339)
+	}
+
+	return e.w.Write([]string{
+		todo.PublicID,
+		todo.CreatedAt.Format(time.RFC3339),
+		csvText(todo.Title),
+		csvText(todo.Description),
+		strconv.FormatBool(todo.Completed),
+		todo.UpdatedAt.Format(time.RFC3339),
+		completedAt,
+	})
+}
+
+func (e *csvEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvText() keeps spreadsheets from running a cell as a formula: text starting
+// with one of the characters that start a formula gets a leading quote, which
+// spreadsheets don't show. The CSV import removes it again
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune(csvFormulaStart, rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// the characters spreadsheets read as the start of a formula
+const csvFormulaStart = "=+-@\t\r"
+
+// jsonArrayEncoder writes the todo tasks as one JSON array, element by element
+type jsonArrayEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonArrayEncoder) begin() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonArrayEncoder) encode(todo *data.Todo) error {
+	js, err := json.Marshal(todo)
+	if err != nil {
+		return err
+	}
+
+	if e.count > 0 {
+		_, err = io.WriteString(e.w, ",")
+		if err != nil {
+			return err
+		}
+	}
+	e.count++
+
+	_, err = e.w.Write(js)
+	return err
+}
+
+func (e *jsonArrayEncoder) end() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// ndjsonEncoder writes a JSON object per line
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) begin() error {
+	return nil
+}
+
+func (e *ndjsonEncoder) encode(todo *data.Todo) error {
+	return e.enc.Encode(todo)
+}
+
+func (e *ndjsonEncoder) end() error {
+	return nil
+}
@@ -0,0 +1,45 @@
+//Filename: cmd/api/export_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"testing"
+)
+
+func TestExportCSVEscapesFormulas(t *testing.T) {
+	app := newTestApplication(t)
+
+	titles := []string{"=HYPERLINK(\"http://example.com\")", "+1", "-1", "@SUM(A1)", "errands", "a = b"}
+	for _, title := range titles {
+		seedTodo(t, app, title)
+	}
+
+	res := app.request(t, http.MethodGet, "/v1/todos/export?format=csv", "", nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(res.body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != len(titles)+1 {
+		t.Fatalf("got %d rows, want a header and %d tasks", len(records), len(titles))
+	}
+
+	want := []string{"'=HYPERLINK(\"http://example.com\")", "'+1", "'-1", "'@SUM(A1)", "errands", "a = b"}
+	for i, record := range records[1:] {
+		if record[2] != want[i] {
+			t.Errorf("title %q, want %q", record[2], want[i])
+		}
+
+		//the import reads back what was exported
+		if got := fromCSVText(record[2]); got != titles[i] {
+			t.Errorf("imported %q, want %q", got, titles[i])
+		}
+	}
+}
@@ -19,7 +19,7 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		},
 	}
 
-	err := app.writeJSON(w, http.StatusOK, data, nil)
+	err := app.writeResponse(w, r, http.StatusOK, data, nil)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
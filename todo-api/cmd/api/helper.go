@@ -3,7 +3,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
@@ -11,8 +13,11 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/vmihailenco/msgpack/v5"
+	"todo.imerlopez.net/internal/data"
 	"todo.imerlopez.net/internal/validator"
 )
 
@@ -36,17 +41,64 @@ func (app *application) readIdParam(r *http.Request) (int64, error) {
 
 }
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+// readPublicIDParam() returns the id parameter when it is a public id, a ULID
+// or the UUID a client put the task under. It is returned as the client wrote
+// it, tasks created under it keep that case and lookups ignore it
+func (app *application) readPublicIDParam(r *http.Request) (string, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id := params.ByName("id")
+	_, ok := data.ParsePublicID(id)
+
+	return id, ok
+}
+
+// readTodo() fetches the todo task named by the id parameter. Clients use the
+// public id, the sequential numbers still work while -numeric-ids is on
+func (app *application) readTodo(r *http.Request) (*data.Todo, error) {
+	if publicID, ok := app.readPublicIDParam(r); ok {
+		return app.models.Todos.GetByPublicID(publicID)
+	}
 
-	//convert map result into JSON data
-	js, err := json.MarshalIndent(data, "", "\t")
+	if !app.config.todos.numericIDs {
+		return nil, data.ErrRecordNotFound
+	}
 
+	id, err := app.readIdParam(r)
 	if err != nil {
-		return err
+		return nil, data.ErrRecordNotFound
 	}
 
-	//For newline,
-	js = append(js, '\n')
+	return app.models.Todos.Get(id)
+}
+
+// writeResponse() writes data in the media type the client prefers, see
+// responseEncoders. Errors that can't be written in an acceptable type fall
+// back to JSON, anything else gets a 406
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+
+	//the representation depends on the Accept header
+	addVary(w.Header(), "Accept")
+
+	enc, err := chooseEncoder(r.Header.Get("Accept"), data)
+	if err != nil {
+		if status < http.StatusBadRequest {
+			//the validators describe the representation that wasn't sent
+			w.Header().Del("ETag")
+			w.Header().Del("Last-Modified")
+
+			app.notAcceptableResponse(w, r)
+			return nil
+		}
+		enc = &responseEncoders[0]
+	}
+
+	//encode first so that a failure can still be answered with a server error
+	var buf bytes.Buffer
+	err = enc.encode(&buf, data, app.pretty(r))
+	if err != nil {
+		return err
+	}
 
 	//add the headers
 
@@ -54,25 +106,68 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 		w.Header()[key] = value
 	}
 
-	//specify that well serve our response using json
-	w.Header().Set("Content-Type", "application/json")
+	//ETags handed in describe the JSON, see representationETag()
+	if etag := headers.Get("ETag"); etag != "" {
+		w.Header().Set("ETag", representationETag(etag, enc))
+	}
+
+	w.Header().Set("Content-Type", enc.contentType)
 	w.WriteHeader(status)
-	//write json as the http body
+	//write the encoded data as the http body
 
-	w.Write(js)
+	w.Write(buf.Bytes())
 
 	return nil
 
 }
 
-func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+// pretty() reports whether a response should be indented for people to read,
+// ?pretty=true asks for it and development servers do it by default
+func (app *application) pretty(r *http.Request) bool {
+	pretty, err := strconv.ParseBool(r.URL.Query().Get("pretty"))
+	if err != nil {
+		return app.config.env == "development"
+	}
+
+	return pretty
+}
+
+// addVary() adds a request header to Vary unless it is already listed
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, listed := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(listed), name) {
+				return
+			}
+		}
+	}
+
+	h.Add("Vary", name)
+}
+
+// readRequest() decodes the request body into dst with the decoder matching its
+// Content-Type, JSON unless it is MessagePack or XML
+func (app *application) readRequest(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	//user http.maxByteReader() to limit the size of the request body to 1 mb 2 ^20
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	format, err := requestFormat(r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case requestMsgpack:
+		return readMsgpack(r.Body, dst, maxBytes)
+	case requestXML:
+		return readXML(r.Body, dst, maxBytes)
+	}
+
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 
-	err := dec.Decode(dst)
+	err = dec.Decode(dst)
 
 	//check for bad request
 
@@ -127,6 +222,53 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst int
 	return nil
 }
 
+// readMsgpack() decodes a MessagePack body, the fields have their json names
+func readMsgpack(body io.Reader, dst interface{}, maxBytes int) error {
+	dec := msgpack.NewDecoder(body)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not larger than %d bytes", maxBytes)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		default:
+			return fmt.Errorf("body contains badly-formed MessagePack: %v", err)
+		}
+	}
+
+	//check for anything after the value
+	if _, err := dec.DecodeInterface(); err != io.EOF {
+		return errors.New("body must only contain a single MessagePack value")
+	}
+
+	return nil
+}
+
+// readXML() decodes an XML body, dst needs xml tags. The root element may have any name
+func readXML(body io.Reader, dst interface{}, maxBytes int) error {
+	err := xml.NewDecoder(body).Decode(dst)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			return fmt.Errorf("body must not larger than %d bytes", maxBytes)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		default:
+			return fmt.Errorf("body contains badly-formed XML: %v", err)
+		}
+	}
+
+	return nil
+}
+
 // the readString method returns a string value from the query parameters
 // or returns a default value if no matching key is found
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -182,3 +324,44 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 	return intValue
 }
+
+// the readTime method parses an RFC 3339 timestamp from the query string
+// if the value cannot be parsed then a validation error is added to
+// the validation errors map
+
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+
+	//get the value
+	value := qs.Get(key)
+
+	if value == "" {
+		return time.Time{}
+	}
+
+	timeValue, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+
+	return timeValue
+}
+
+// the readTodoSearch method reads the title and time range criteria used to
+// select todo tasks, problems are added to the validation errors map
+func (app *application) readTodoSearch(qs url.Values, v *validator.Validator) data.TodoSearch {
+	var search data.TodoSearch
+
+	search.Title = app.readString(qs, "title", "")
+
+	search.UpdatedAt.Since = app.readTime(qs, "updated_since", v)
+	search.UpdatedAt.Before = app.readTime(qs, "updated_before", v)
+	search.CompletedAt.Since = app.readTime(qs, "completed_since", v)
+	search.CompletedAt.Before = app.readTime(qs, "completed_before", v)
+
+	data.ValidateTimeRange(v, "updated", search.UpdatedAt)
+	data.ValidateTimeRange(v, "completed", search.CompletedAt)
+
+	return search
+}
@@ -0,0 +1,170 @@
+//Filename: cmd/api/ical.go
+
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"todo.imerlopez.net/internal/data"
+	"todo.imerlopez.net/internal/ical"
+	"todo.imerlopez.net/internal/validator"
+)
+
+// the product identifier written into every calendar
+const icalProdID = "-//imerlopez.net//Todo API//EN"
+
+// todoUID() is the iCalendar UID of a todo task
+func todoUID(publicID string) string {
+	return fmt.Sprintf("todo-%s@todo.imerlopez.net", publicID)
+}
+
+// newCalendar() returns an empty VCALENDAR
+func newCalendar() *ical.Component {
+	cal := ical.NewComponent("VCALENDAR")
+	cal.Add("VERSION", "2.0")
+	cal.Add("PRODID", icalProdID)
+	cal.AddText("X-WR-CALNAME", "Todos")
+	return cal
+}
+
+// vtodo() describes a todo task as a VTODO component. Tasks have no due date,
+// so there is no DUE property
+func vtodo(todo *data.Todo) *ical.Component {
+	c := ical.NewComponent("VTODO")
+	c.Add("UID", todoUID(todo.PublicID))
+	c.AddTime("DTSTAMP", todo.UpdatedAt)
+	c.AddTime("CREATED", todo.CreatedAt)
+	c.AddTime("LAST-MODIFIED", todo.UpdatedAt)
+	c.AddText("SUMMARY", todo.Title)
+	c.AddText("DESCRIPTION", todo.Description)
+
+	if todo.Completed {
+		c.Add("STATUS", "COMPLETED")
+		c.Add("PERCENT-COMPLETE", "100")
+		if todo.CompletedAt != nil {
+			c.AddTime("COMPLETED", *todo.CompletedAt)
+		}
+	} else {
+		c.Add("STATUS", "NEEDS-ACTION")
+	}
+
+	return c
+}
+
+// readVTODO() copies the summary, description and status of a VTODO into a todo task
+func readVTODO(c *ical.Component, todo *data.Todo) {
+	todo.Title = c.Text("SUMMARY")
+	todo.Description = c.Text("DESCRIPTION")
+	todo.Completed = strings.EqualFold(c.Text("STATUS"), "COMPLETED") || c.Get("COMPLETED") != nil
+}
+
+// icsEncoder writes the todo tasks as the VTODOs of one calendar
+type icsEncoder struct {
+	enc *ical.Encoder
+	cal *ical.Component
+}
+
+func newICSEncoder(w io.Writer) *icsEncoder {
+	return &icsEncoder{enc: ical.NewEncoder(w), cal: newCalendar()}
+}
+
+// begin() writes the calendar and its properties but not its END line
+func (e *icsEncoder) begin() error {
+	err := e.enc.Begin(e.cal.Name)
+	if err != nil {
+		return err
+	}
+
+	//the VTODOs follow the calendar's own properties
+	for _, prop := range e.cal.Props {
+		err = e.enc.Property(prop)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *icsEncoder) encode(todo *data.Todo) error {
+	return e.enc.Encode(vtodo(todo))
+}
+
+func (e *icsEncoder) end() error {
+	return e.enc.End(e.cal.Name)
+}
+
+// icsFeedHandler serves every todo task matching the list filters as an iCalendar
+// feed that calendar apps can subscribe to. The feed is only served to requests
+// with ?token= set to -ics-token, without a token there is no feed
+func (app *application) icsFeedHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	if app.config.ics.token == "" {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	token := qs.Get("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(app.config.ics.token)) != 1 {
+		app.invalidFeedTokenResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	search := app.readTodoSearch(qs, v)
+
+	filters := data.Filters{
+		Sort:     app.readString(qs, "sort", "id"),
+		SortList: data.TodoSortList,
+	}
+	v.Check(validator.In(filters.Sort, filters.SortList...), "sort", "invalid sort value")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", "text/calendar; charset=utf-8")
+	headers.Set("Content-Disposition", `inline; filename="todos.ics"`)
+
+	app.writeTodos(w, r, search, filters, newICSEncoder(w), headers)
+}
+
+// parseICSImport() reads the VTODO components of a calendar, other components
+// such as events are skipped
+func parseICSImport(in io.Reader) ([]importRow, error) {
+	cal, err := ical.Parse(in)
+	if err != nil {
+		if errors.Is(err, ical.ErrInvalid) {
+			return nil, errImportFormat{"is not a valid iCalendar file: " + strings.TrimPrefix(err.Error(), ical.ErrInvalid.Error()+": ")}
+		}
+		return nil, err
+	}
+
+	if cal.Name != "VCALENDAR" {
+		return nil, errImportFormat{"must be a VCALENDAR"}
+	}
+
+	rows := []importRow{}
+
+	for i, c := range cal.Find("VTODO") {
+		todo := &data.Todo{}
+		readVTODO(c, todo)
+
+		if strings.TrimSpace(todo.Description) == "" {
+			todo.Description = "Imported from iCalendar"
+		}
+
+		rows = append(rows, importRow{Row: i + 1, Todo: todo})
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,41 @@
+//Filename: cmd/api/ical_test.go
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestICSFeedToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		query      string
+		status     int
+	}{
+		{"no token configured", "", "", http.StatusNotFound},
+		{"no token configured, token sent", "", "?token=feed-secret", http.StatusNotFound},
+		{"missing token", "feed-secret", "", http.StatusUnauthorized},
+		{"wrong token", "feed-secret", "?token=guess", http.StatusUnauthorized},
+		{"token", "feed-secret", "?token=feed-secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.ics.token = tt.configured
+			seedTodo(t, app, "errands")
+
+			res := app.request(t, http.MethodGet, "/v1/todos.ics"+tt.query, "", nil)
+			if res.status != tt.status {
+				t.Fatalf("status %d, want %d: %s", res.status, tt.status, res.body)
+			}
+
+			if tt.status == http.StatusOK && !strings.Contains(string(res.body), "SUMMARY:errands") {
+				t.Errorf("the feed doesn't have the task:\n%s", res.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,401 @@
+//Filename: cmd/api/import.go
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"todo.imerlopez.net/internal/data"
+	"todo.imerlopez.net/internal/validator"
+)
+
+const (
+	maxImportBytes = 10 << 20
+	maxImportRows  = 5000
+)
+
+// an importRow is a todo task read from an uploaded file. Row is the line of
+// a CSV file or the position in a JSON array or calendar, counting from 1
+type importRow struct {
+	Row    int
+	Todo   *data.Todo
+	Errors map[string]string
+}
+
+// a rowError reports why a row of an import can't be created
+type rowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// an importPreview is a task a dry run would create
+type importPreview struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Completed   bool   `json:"completed"`
+}
+
+// errImportFormat is returned by the parsers for files that don't have the expected shape
+type errImportFormat struct {
+	message string
+}
+
+func (e errImportFormat) Error() string {
+	return e.message
+}
+
+// the parsers for each import format
+var importParsers = map[string]func(io.Reader) ([]importRow, error){
+	"csv":     parseCSVImport,
+	"json":    parseJSONImport,
+	"todoist": parseTodoistImport,
+	"trello":  parseTrelloImport,
+	"ics":     parseICSImport,
+}
+
+// importTodosHandler creates todo tasks from an uploaded file. Every row is validated
+// first and the tasks are only created, in one transaction, when all of them are valid
+func (app *application) importTodosHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		v.Check(err == nil, "dry_run", "must be true or false")
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	err := r.ParseMultipartForm(maxImportBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			app.badRequestResponse(w, r, fmt.Errorf("file must not be larger than %d bytes", maxImportBytes))
+			return
+		}
+		app.badRequestResponse(w, r, fmt.Errorf("body must be a multipart form: %w", err))
+		return
+	}
+
+	defer r.MultipartForm.RemoveAll()
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("the form must have a file field"))
+		return
+	}
+
+	defer file.Close()
+
+	//detect the format from the contents unless the client says what it is
+	in := bufio.NewReader(file)
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = detectImportFormat(in)
+	}
+
+	parse, ok := importParsers[format]
+	v.Check(ok, "format", "must be csv, json, todoist, trello or ics")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rows, err := parse(in)
+	if err != nil {
+		var formatError errImportFormat
+		if errors.As(err, &formatError) {
+			app.failedValidationResponse(w, r, map[string]string{"file": formatError.message})
+			return
+		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if len(rows) > maxImportRows {
+		app.failedValidationResponse(w, r, map[string]string{"file": fmt.Sprintf("must not contain more than %d tasks", maxImportRows)})
+		return
+	}
+
+	//validate every row and report all of the problems at once
+	todos := []*data.Todo{}
+	problems := []rowError{}
+
+	for _, row := range rows {
+		//rows that couldn't be read aren't validated any further
+		if len(row.Errors) > 0 {
+			problems = append(problems, rowError{Row: row.Row, Errors: row.Errors})
+			continue
+		}
+
+		rv := validator.New()
+		if data.ValidateTodo(rv, row.Todo); !rv.Valid() {
+			problems = append(problems, rowError{Row: row.Row, Errors: rv.Errors})
+			continue
+		}
+
+		todos = append(todos, row.Todo)
+	}
+
+	if len(problems) > 0 {
+		app.errorRepsonse(w, r, http.StatusUnprocessableEntity, envelope{"rows": problems})
+		return
+	}
+
+	//a dry run shows what would be created, the tasks have no ids or timestamps yet
+	if dryRun {
+		preview := []importPreview{}
+		for _, todo := range todos {
+			preview = append(preview, importPreview{Title: todo.Title, Description: todo.Description, Completed: todo.Completed})
+		}
+
+		err = app.writeResponse(w, r, http.StatusOK, envelope{"dry_run": true, "count": len(todos), "todos": preview}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Todos.InsertMany(todos)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"count": len(todos), "todos": todos}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// detectImportFormat() guesses the format of a file from its first bytes, a
+// JSON object is taken to be a Trello board, CSV headers that start with
+// TYPE,CONTENT to be a Todoist template and BEGIN:VCALENDAR an iCalendar file
+func detectImportFormat(in *bufio.Reader) string {
+	head, _ := in.Peek(512)
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	head = bytes.TrimSpace(head)
+
+	switch {
+	case bytes.HasPrefix(head, []byte("[")):
+		return "json"
+	case bytes.HasPrefix(head, []byte("{")):
+		return "trello"
+	case bytes.HasPrefix(bytes.ToUpper(head), []byte("BEGIN:VCALENDAR")):
+		return "ics"
+	case bytes.HasPrefix(bytes.ToUpper(head), []byte("TYPE,CONTENT")):
+		return "todoist"
+	default:
+		return "csv"
+	}
+}
+
+// readCSV() reads the header and records of a CSV file and calls fn with each
+// record keyed by its lower case column name
+func readCSV(in io.Reader, required string, fn func(line int, record map[string]string)) error {
+	cr := csv.NewReader(in)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return errImportFormat{"must not be empty"}
+	}
+	if err != nil {
+		return errImportFormat{"is not a valid CSV file: " + err.Error()}
+	}
+
+	columns := make([]string, len(header))
+	found := false
+	for i, name := range header {
+		columns[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		found = found || columns[i] == required
+	}
+
+	if !found {
+		return errImportFormat{fmt.Sprintf("must have a %s column", required)}
+	}
+
+	for {
+		fields, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errImportFormat{"is not a valid CSV file: " + err.Error()}
+		}
+
+		line, _ := cr.FieldPos(0)
+
+		record := make(map[string]string)
+		for i, value := range fields {
+			if i < len(columns) {
+				record[columns[i]] = value
+			}
+		}
+
+		fn(line, record)
+	}
+}
+
+// parseCSVImport() reads title, description and completed columns, other
+// columns such as those of an export are ignored
+func parseCSVImport(in io.Reader) ([]importRow, error) {
+	rows := []importRow{}
+
+	err := readCSV(in, "title", func(line int, record map[string]string) {
+		row := importRow{
+			Row: line,
+			Todo: &data.Todo{
+				Title:       fromCSVText(record["title"]),
+				Description: fromCSVText(record["description"]),
+			},
+		}
+
+		//spreadsheets often say yes or no
+		switch strings.ToLower(strings.TrimSpace(record["completed"])) {
+		case "", "false", "f", "0", "no", "n":
+		case "true", "t", "1", "yes", "y":
+			row.Todo.Completed = true
+		default:
+			row.Errors = map[string]string{"completed": "must be true or false"}
+		}
+
+		rows = append(rows, row)
+	})
+
+	return rows, err
+}
+
+// fromCSVText() removes the quote csvText() puts before text that would start a formula
+func fromCSVText(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune(csvFormulaStart, rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+// parseJSONImport() reads an array of todo tasks like the one written by the export
+func parseJSONImport(in io.Reader) ([]importRow, error) {
+	var items []json.RawMessage
+
+	err := json.NewDecoder(in).Decode(&items)
+	if err != nil {
+		return nil, errImportFormat{"must be a JSON array of todo tasks"}
+	}
+
+	rows := []importRow{}
+
+	for i, item := range items {
+		var input struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+			Completed   bool   `json:"completed"`
+		}
+
+		row := importRow{Row: i + 1, Todo: &data.Todo{}}
+
+		err := json.Unmarshal(item, &input)
+		if err != nil {
+			row.Errors = map[string]string{"task": "must be an object with title, description and completed fields"}
+		}
+
+		row.Todo.Title = input.Title
+		row.Todo.Description = input.Description
+		row.Todo.Completed = input.Completed
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// parseTodoistImport() reads a Todoist CSV template, its tasks are rows of
+// TYPE task and sections and notes are skipped. The export only holds open tasks
+func parseTodoistImport(in io.Reader) ([]importRow, error) {
+	rows := []importRow{}
+
+	err := readCSV(in, "content", func(line int, record map[string]string) {
+		if !strings.EqualFold(record["type"], "task") {
+			return
+		}
+
+		description := record["description"]
+		if strings.TrimSpace(description) == "" {
+			description = "Imported from Todoist"
+		}
+
+		rows = append(rows, importRow{
+			Row: line,
+			Todo: &data.Todo{
+				Title:       record["content"],
+				Description: description,
+			},
+		})
+	})
+
+	return rows, err
+}
+
+// parseTrelloImport() reads the cards of an exported Trello board, archived
+// cards are skipped and a card is completed when its due date is marked complete
+func parseTrelloImport(in io.Reader) ([]importRow, error) {
+	var board struct {
+		Lists []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"lists"`
+		Cards []struct {
+			Name        string `json:"name"`
+			Desc        string `json:"desc"`
+			Closed      bool   `json:"closed"`
+			DueComplete bool   `json:"dueComplete"`
+			IDList      string `json:"idList"`
+		} `json:"cards"`
+	}
+
+	err := json.NewDecoder(in).Decode(&board)
+	if err != nil || board.Cards == nil {
+		return nil, errImportFormat{"must be an exported Trello board"}
+	}
+
+	lists := make(map[string]string)
+	for _, list := range board.Lists {
+		lists[list.ID] = list.Name
+	}
+
+	rows := []importRow{}
+
+	for i, card := range board.Cards {
+		if card.Closed {
+			continue
+		}
+
+		description := card.Desc
+		if strings.TrimSpace(description) == "" {
+			description = fmt.Sprintf("Imported from Trello list %q", lists[card.IDList])
+		}
+
+		rows = append(rows, importRow{
+			Row: i + 1,
+			Todo: &data.Todo{
+				Title:       card.Name,
+				Description: description,
+				Completed:   card.DueComplete,
+			},
+		})
+	}
+
+	return rows, nil
+}
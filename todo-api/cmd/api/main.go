@@ -3,15 +3,17 @@
 package main
 
 import (
-	"context"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
+	"html/template"
 	"os"
 	"time"
 
-	_ "github.com/lib/pq"
 	"todo.imerlopez.net/internal/data"
 	"todo.imerlopez.net/internal/jsonlog"
+	"todo.imerlopez.net/internal/migrate"
 )
 
 // App Verison
@@ -19,13 +21,33 @@ const version = "1.0.0"
 
 // Config Settings
 type config struct {
-	port int
-	env  string // dev, staging, prod
-	db   struct {
+	port    int
+	env     string // dev, staging, prod
+	storage string // database, memory
+	db      struct {
+		driver       string // postgres, sqlite
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		automigrate  bool
+	}
+	ics struct {
+		token string // secret required to read the iCalendar feed, the feed is open when empty
+	}
+	ui struct {
+		enabled bool
+	}
+	todos struct {
+		clientIDs  bool // PUT may create todo tasks under UUIDs chosen by the client
+		numericIDs bool // the sequential ids are still accepted in URLs
+	}
+	compression struct {
+		enabled bool
+		minSize int // responses smaller than this are sent uncompressed
+	}
+	session struct {
+		secret string // key signing the session cookie of the HTML interface
 	}
 	limiter struct {
 		rps     float64 //request per sec
@@ -40,6 +62,10 @@ type application struct {
 	config config
 	logger *jsonlog.Logger
 	models data.Models
+	events *broker
+	hub    *hub
+	ui     map[string]*uiAsset
+	pages  map[string]*template.Template
 }
 
 func main() {
@@ -48,10 +74,20 @@ func main() {
 	//read in the flages that are needed to populate our config
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment: Dev, Staging, prod")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("TODO_DB_DSN"), "PostgreSQL DSN")
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.StringVar(&cfg.storage, "storage", "database", "Storage backend: database, memory")
+	flag.StringVar(&cfg.db.driver, "db-driver", "postgres", "Database driver: postgres, sqlite")
+	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("TODO_DB_DSN"), "PostgreSQL DSN or SQLite database file")
+	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "Database max open connections")
+	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "Database max idle connections")
+	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "Database max connection idle time")
+	flag.BoolVar(&cfg.db.automigrate, "db-automigrate", true, "Apply pending migrations on startup")
+	flag.BoolVar(&cfg.compression.enabled, "compression-enabled", true, "Compress responses with zstd, gzip or deflate")
+	flag.IntVar(&cfg.compression.minSize, "compression-min-size", 1024, "Smallest response in bytes worth compressing")
+	flag.BoolVar(&cfg.todos.numericIDs, "numeric-ids", true, "Accept the sequential todo ids in URLs next to the public ids, while clients move over")
+	flag.BoolVar(&cfg.todos.clientIDs, "client-ids", true, "Let PUT /v1/todos/:id create todo tasks under client supplied UUIDs")
+	flag.BoolVar(&cfg.ui.enabled, "ui-enabled", true, "Serve the todo-ui frontend next to the API")
+	flag.StringVar(&cfg.session.secret, "session-secret", os.Getenv("TODO_SESSION_SECRET"), "Key signing the session cookie of the HTML interface (random when empty)")
+	flag.StringVar(&cfg.ics.token, "ics-token", os.Getenv("TODO_ICS_TOKEN"), "Secret token required to read the iCalendar feed (no feed when empty)")
 	//flags for rate limiter
 	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
 	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
@@ -61,25 +97,114 @@ func main() {
 
 	//logger
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
-	//create connection pool
-	db, err := openDB(cfg)
-	if err != nil {
-		logger.PrintFatal(err, nil)
+
+	//`api migrate up|down|status|goto N` manages the schema instead of serving
+	command := flag.Arg(0)
+	if command != "" && command != "migrate" {
+		logger.PrintFatal(fmt.Errorf("unknown command %q", command), nil)
 	}
 
-	defer db.Close()
+	var models data.Models
+
+	switch cfg.storage {
+	case "database":
+		//create connection pool
+		db, err := openDB(cfg)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		defer db.Close()
+
+		//log the successful connection pool
+		logger.PrintInfo("Database Connection pool established", map[string]string{
+			"driver": cfg.db.driver,
+		})
+
+		migrator, err := newMigrator(db, cfg.db.driver)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		if command == "migrate" {
+			err = runMigrateCommand(migrator, flag.Args()[1:], os.Stdout)
+			if err != nil {
+				logger.PrintFatal(err, nil)
+			}
+			return
+		}
+
+		if cfg.db.automigrate {
+			err = migrator.Up()
+			if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+				logger.PrintFatal(err, nil)
+			}
+
+			logger.PrintInfo("Database schema is up to date", nil)
+		}
+
+		models = data.NewDatabaseModels(db, cfg.db.driver, cfg.db.dsn)
+
+	case "memory":
+		if command == "migrate" {
+			logger.PrintFatal(errors.New("migrate needs -storage=database"), nil)
+		}
+
+		//everything is lost when the server stops
+		logger.PrintInfo("Using in-memory storage", nil)
 
-	//log the successful connection pool
-	logger.PrintInfo("Database Connection pool established", nil)
+		models = data.NewMemoryModels()
+
+	default:
+		logger.PrintFatal(fmt.Errorf("unknown storage backend %q", cfg.storage), nil)
+	}
+
+	//the embedded frontend, nil when it is disabled
+	var ui map[string]*uiAsset
+	if cfg.ui.enabled {
+		var err error
+		ui, err = loadUI()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
+	//the HTML interface signs its session cookie, a random key logs everyone
+	//out when the server restarts
+	if cfg.session.secret == "" {
+		secret, err := newSessionSecret()
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		cfg.session.secret = secret
+	}
+
+	pages, err := loadPages()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 
 	//Create an instance of our application struct
 
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
+		models: models,
+		events: newBroker(),
+		hub:    newHub(),
+		ui:     ui,
+		pages:  pages,
 	}
 
+	//the hub delivers the same events to websocket clients
+	go app.hub.run()
+
+	//stored idempotent responses are only kept for a day
+	go app.expireIdempotencyKeys()
+
+	//publish changes made to the todo table by any instance
+	go app.listenForEvents()
+
 	// call the app.serve to start the server
 	err = app.serve()
 
@@ -92,7 +217,7 @@ func main() {
 
 // open db function return a *sql.DB connection pool
 func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+	db, err := data.OpenDB(cfg.db.driver, cfg.db.dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -100,19 +225,10 @@ func openDB(cfg config) (*sql.DB, error) {
 	db.SetMaxIdleConns(cfg.db.maxIdleConns)
 	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 	db.SetConnMaxIdleTime(duration)
-	//create a context with a 5 sec timeout deadline
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	err = db.PingContext(ctx)
-
-	if err != nil {
-		return nil, err
-	}
 
 	return db, nil
-
 }
@@ -0,0 +1,165 @@
+//Filename: cmd/api/middleware.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// the responseRecorder passes a response through to the client while keeping a copy.
+// The headers are copied as the handler sent them, writers further down such as
+// the compressor change the shared header map for their encoding of the body
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap() lets http.ResponseController reach the underlying writer
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// idempotent() makes a handler safe to retry. Requests carrying an Idempotency-Key
+// header are processed once and the stored response is replayed for retries
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > 255 {
+			app.badRequestResponse(w, r, errors.New("idempotency key must not be more than 255 bytes long"))
+			return
+		}
+
+		//read the body so it can be fingerprinted, readRequest applies the same limit
+		maxBytes := 1_048_576
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("body must not larger than %d bytes", maxBytes))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fp := fingerprint(r, body)
+
+		stored, err := app.models.Idempotency.Reserve(key, fp)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if stored != nil {
+			switch {
+			case stored.Fingerprint != fp:
+				app.errorRepsonse(w, r, http.StatusUnprocessableEntity, "the idempotency key was already used for a different request")
+			case stored.Status == 0:
+				w.Header().Set("Retry-After", "1")
+				app.errorRepsonse(w, r, http.StatusConflict, "a request with this idempotency key is still being processed")
+			default:
+				//replay the original response
+				for name, values := range stored.Headers {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(stored.Status)
+				w.Write(stored.Body)
+			}
+			return
+		}
+
+		//the key is released unless the response was stored, this runs as well
+		//when the handler panics, so that the client can try again
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+
+			err := app.models.Idempotency.Release(key)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next(rec, r)
+
+		//server errors are not stored
+		if rec.status == 0 || rec.status >= 500 {
+			return
+		}
+
+		err = app.models.Idempotency.Complete(key, rec.status, rec.header, rec.body.Bytes())
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		completed = true
+	}
+}
+
+// fingerprint() identifies a request by its method, path and body. JSON bodies
+// are compacted first so that formatting differences don't matter
+func fingerprint(r *http.Request, body []byte) string {
+	var compact bytes.Buffer
+	if json.Compact(&compact, body) == nil {
+		body = compact.Bytes()
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.Path)
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// expireIdempotencyKeys() periodically removes idempotency keys past their lifetime
+func (app *application) expireIdempotencyKeys() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := app.models.Idempotency.DeleteExpired()
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			continue
+		}
+
+		if deleted > 0 {
+			app.logger.PrintInfo("expired idempotency keys deleted", map[string]string{
+				"count": fmt.Sprint(deleted),
+			})
+		}
+	}
+}
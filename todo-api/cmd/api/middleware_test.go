@@ -0,0 +1,97 @@
+//Filename: cmd/api/middleware_test.go
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIdempotentReplayIsCompressed(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.compression.enabled = true
+	app.config.compression.minSize = 1024
+
+	//large enough to be compressed
+	body := `{"title":"errands","description":"` + strings.Repeat("milk and bread ", 100) + `"}`
+	headers := map[string]string{"Idempotency-Key": "replay-compressed", "Accept-Encoding": "gzip"}
+
+	first := app.request(t, http.MethodPost, "/v1/todos", body, headers)
+	replay := app.request(t, http.MethodPost, "/v1/todos", body, headers)
+
+	if replay.headers.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("the second request wasn't replayed: %d %s", replay.status, replay.body)
+	}
+
+	for name, res := range map[string]testResponse{"first": first, "replay": replay} {
+		if res.status != http.StatusCreated {
+			t.Fatalf("%s: status %d, want %d", name, res.status, http.StatusCreated)
+		}
+		if res.headers.Get("Content-Encoding") != "gzip" {
+			t.Fatalf("%s: Content-Encoding %q, want gzip", name, res.headers.Get("Content-Encoding"))
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(res.body))
+		if err != nil {
+			t.Fatalf("%s: the body isn't gzip: %v", name, err)
+		}
+
+		js, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !json.Valid(js) {
+			t.Errorf("%s: the body isn't JSON once decompressed", name)
+		}
+	}
+
+	if first.headers.Get("ETag") != replay.headers.Get("ETag") {
+		t.Errorf("ETag %q on replay, want %q", replay.headers.Get("ETag"), first.headers.Get("ETag"))
+	}
+	if vary := replay.headers.Values("Vary"); strings.Count(strings.Join(vary, ","), "Accept-Encoding") != 1 {
+		t.Errorf("Vary %q on replay, want Accept-Encoding once", vary)
+	}
+}
+
+func TestIdempotentReleasesKeyAfterPanic(t *testing.T) {
+	app := newTestApplication(t)
+
+	calls := 0
+	handler := app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v1/todos", strings.NewReader(`{"title":"errands"}`))
+		r.Header.Set("Idempotency-Key", "panicked")
+
+		rr := httptest.NewRecorder()
+		handler(rr, r)
+		return rr
+	}
+
+	func() {
+		//net/http recovers panics of handlers the same way
+		defer func() { recover() }()
+		send()
+	}()
+
+	rr := send()
+	if rr.Code != http.StatusCreated {
+		t.Errorf("retry after a panic: status %d, want %d", rr.Code, http.StatusCreated)
+	}
+	if calls != 2 {
+		t.Errorf("the handler ran %d times, want 2", calls)
+	}
+}
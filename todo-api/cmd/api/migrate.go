@@ -0,0 +1,93 @@
+//Filename: cmd/api/migrate.go
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"todo.imerlopez.net/internal/migrate"
+	"todo.imerlopez.net/migrations"
+)
+
+// newMigrator() loads the embedded migrations for the database driver
+func newMigrator(db *sql.DB, driver string) (migrate.Migrator, error) {
+	fsys, dir := migrations.Postgres, "."
+	if driver == "sqlite" {
+		fsys, dir = migrations.SQLite, "sqlite"
+	}
+
+	list, err := migrate.Load(fsys, dir)
+	if err != nil {
+		return migrate.Migrator{}, err
+	}
+
+	return migrate.Migrator{DB: db, Driver: driver, Migrations: list}, nil
+}
+
+// runMigrateCommand() handles `api migrate up|down|status|goto N`
+func runMigrateCommand(m migrate.Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|status|goto N")
+	}
+
+	var err error
+
+	switch args[0] {
+	case "up":
+		err = m.Up()
+	case "down":
+		err = m.Down()
+	case "goto":
+		if len(args) != 2 {
+			return errors.New("usage: migrate goto N")
+		}
+
+		version, convErr := strconv.ParseInt(args[1], 10, 64)
+		if convErr != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+
+		err = m.Goto(version)
+	case "status":
+		return printMigrationStatus(m, out)
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+
+	if errors.Is(err, migrate.ErrNoChange) {
+		fmt.Fprintln(out, "no change")
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return printMigrationStatus(m, out)
+}
+
+// printMigrationStatus() writes a table of the migrations and when they were applied
+func printMigrationStatus(m migrate.Migrator, out io.Writer) error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+	}
+
+	return tw.Flush()
+}
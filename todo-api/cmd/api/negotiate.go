@@ -0,0 +1,355 @@
+//Filename: cmd/api/negotiate.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"todo.imerlopez.net/internal/data"
+)
+
+// a responseEncoder writes an envelope in one media type
+type responseEncoder struct {
+	mediaType   string
+	contentType string
+	encode      func(w io.Writer, env envelope, pretty bool) error
+	//canEncode is nil when every envelope can be written
+	canEncode func(env envelope) bool
+}
+
+// the media types responses can be written in, in order of preference when the
+// client accepts several equally
+var responseEncoders = []responseEncoder{
+	{"application/json", "application/json", encodeJSON, nil},
+	{"application/msgpack", "application/msgpack", encodeMsgpack, nil},
+	{"application/x-msgpack", "application/x-msgpack", encodeMsgpack, nil},
+	{"application/xml", "application/xml; charset=utf-8", encodeXML, nil},
+	{"text/xml", "text/xml; charset=utf-8", encodeXML, nil},
+	{"text/csv", "text/csv; charset=utf-8", encodeCSV, isTodoList},
+}
+
+// errNotAcceptable is returned by chooseEncoder() when the client accepts none
+// of the media types the response can be written in
+var errNotAcceptable = errors.New("not acceptable")
+
+// errUnsupportedMediaType is returned for request bodies none of the decoders can read
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// chooseEncoder() picks the encoder for a response from the Accept header
+func chooseEncoder(accept string, env envelope) (*responseEncoder, error) {
+	ranges := parseAccept(accept)
+
+	var best *responseEncoder
+	bestQ := 0.0
+
+	for i := range responseEncoders {
+		enc := &responseEncoders[i]
+		if enc.canEncode != nil && !enc.canEncode(env) {
+			continue
+		}
+
+		//a missing Accept header accepts everything
+		q := 1.0
+		if len(ranges) > 0 {
+			q = acceptQuality(ranges, enc.mediaType)
+		}
+
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+
+	if best == nil {
+		return nil, errNotAcceptable
+	}
+
+	return best, nil
+}
+
+// negotiate() picks the encoder of a response before it is written, for the
+// conditional checks that depend on it. It writes the 406 itself and returns
+// nil when the client accepts none of the media types
+func (app *application) negotiate(w http.ResponseWriter, r *http.Request, env envelope) *responseEncoder {
+	addVary(w.Header(), "Accept")
+
+	enc, err := chooseEncoder(r.Header.Get("Accept"), env)
+	if err != nil {
+		app.notAcceptableResponse(w, r)
+		return nil
+	}
+
+	return enc
+}
+
+// a mediaRange is one entry of an Accept header
+type mediaRange struct {
+	typ     string
+	subtype string
+	q       float64
+}
+
+// parseAccept() reads the media ranges of an Accept header, entries that can't
+// be parsed are skipped
+func parseAccept(accept string) []mediaRange {
+	ranges := []mediaRange{}
+
+	for _, part := range strings.Split(accept, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+
+		typ, subtype, ok := strings.Cut(mediaType, "/")
+		if !ok {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		ranges = append(ranges, mediaRange{typ: typ, subtype: subtype, q: q})
+	}
+
+	return ranges
+}
+
+// acceptQuality() returns the quality the client gives mediaType, taken from the
+// most specific range that matches it. Zero means it isn't acceptable
+func acceptQuality(ranges []mediaRange, mediaType string) float64 {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+
+	q, specificity := 0.0, -1
+	for _, mr := range ranges {
+		s := -1
+		switch {
+		case mr.typ == typ && mr.subtype == subtype:
+			s = 2
+		case mr.typ == typ && mr.subtype == "*":
+			s = 1
+		case mr.typ == "*" && mr.subtype == "*":
+			s = 0
+		}
+
+		if s > specificity {
+			q, specificity = mr.q, s
+		}
+	}
+
+	return q
+}
+
+// encodeJSON() writes compact JSON, indented when it is meant to be read
+func encodeJSON(w io.Writer, env envelope, pretty bool) error {
+	var js []byte
+	var err error
+
+	if pretty {
+		js, err = json.MarshalIndent(env, "", "\t")
+	} else {
+		js, err = json.Marshal(env)
+	}
+	if err != nil {
+		return err
+	}
+
+	//For newline,
+	js = append(js, '\n')
+
+	_, err = w.Write(js)
+	return err
+}
+
+// encodeMsgpack() writes MessagePack using the json field names, times become
+// MessagePack timestamps
+func encodeMsgpack(w io.Writer, env envelope, pretty bool) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+
+	return enc.Encode(env)
+}
+
+// isTodoList() reports whether the envelope holds a list of todo tasks, the only
+// thing that can be written as CSV
+func isTodoList(env envelope) bool {
+	_, ok := env["todos"].([]*data.Todo)
+	return ok
+}
+
+// encodeCSV() writes the todo tasks of a list in the same columns as the CSV export
+func encodeCSV(w io.Writer, env envelope, pretty bool) error {
+	enc := &csvEncoder{w: csv.NewWriter(w)}
+
+	err := enc.begin()
+	if err != nil {
+		return err
+	}
+
+	for _, todo := range env["todos"].([]*data.Todo) {
+		err = enc.encode(todo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return enc.end()
+}
+
+// element names that can be written as they are, other keys become
+// <entry key="..."> elements
+var xmlNameRX = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// encodeXML() writes the JSON representation of the envelope as XML under a
+// <response> element. Object members become elements in the same order, array
+// items are named after their array, e.g. <todos><todo>..</todo></todos>
+func encodeXML(w io.Writer, env envelope, pretty bool) error {
+	js, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	_, err = io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	if pretty {
+		enc.Indent("", "\t")
+	}
+
+	err = writeXMLValue(enc, dec, "response")
+	if err != nil {
+		return err
+	}
+
+	err = enc.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// writeXMLValue() converts the next JSON value of dec into an element called name
+func writeXMLValue(enc *xml.Encoder, dec *json.Decoder, name string) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !xmlNameRX.MatchString(name) {
+		start = xml.StartElement{
+			Name: xml.Name{Local: "entry"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+		}
+	}
+
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		err = enc.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+
+		for dec.More() {
+			var child string
+
+			if token == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = key.(string)
+			} else {
+				child = itemName(name)
+			}
+
+			err = writeXMLValue(enc, dec, child)
+			if err != nil {
+				return err
+			}
+		}
+
+		//the closing delimiter
+		_, err = dec.Token()
+		if err != nil {
+			return err
+		}
+
+		return enc.EncodeToken(start.End())
+
+	case nil:
+		//an empty element stands for null
+		return enc.EncodeElement("", start)
+
+	default:
+		return enc.EncodeElement(fmt.Sprint(token), start)
+	}
+}
+
+// itemName() names the items of an array element, "todos" holds <todo> items
+func itemName(name string) string {
+	if strings.HasSuffix(name, "s") && len(name) > 1 {
+		return strings.TrimSuffix(name, "s")
+	}
+	return "item"
+}
+
+// the formats request bodies can be decoded from
+const (
+	requestMsgpack = "msgpack"
+	requestXML     = "xml"
+	requestJSON    = "json"
+)
+
+// requestFormat() picks the decoder for a Content-Type. Bodies without one, or
+// sent as a form by `curl -d`, have always been read as JSON
+func requestFormat(contentType string) (string, error) {
+	if contentType == "" {
+		return requestJSON, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUnsupportedMediaType, err)
+	}
+
+	switch {
+	case mediaType == "application/msgpack" || mediaType == "application/x-msgpack":
+		return requestMsgpack, nil
+	case mediaType == "application/xml" || mediaType == "text/xml":
+		return requestXML, nil
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/x-www-form-urlencoded", mediaType == "text/plain":
+		return requestJSON, nil
+	default:
+		return "", fmt.Errorf("%w %s", errUnsupportedMediaType, mediaType)
+	}
+}
@@ -0,0 +1,78 @@
+//Filename: cmd/api/openapi.go
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// openapi.json is maintained by hand, the tests check it against endpoints() with checkOpenAPI()
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// serve the OpenAPI 3 document of the API
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// checkOpenAPI() compares the documented operations with the registered routes
+// and reports every route missing from the spec and every operation without a route
+func checkOpenAPI(spec []byte, endpoints []route) error {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+
+	err := json.Unmarshal(spec, &doc)
+	if err != nil {
+		return fmt.Errorf("openapi.json: %w", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for method := range item {
+			//path items may also hold shared parameters, servers and so on
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	registered := make(map[string]bool)
+	for _, rt := range endpoints {
+		//httprouter's :name parameters are written {name} in OpenAPI
+		segments := strings.Split(rt.path, "/")
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, ":") {
+				segments[i] = "{" + segment[1:] + "}"
+			}
+		}
+		registered[rt.method+" "+strings.Join(segments, "/")] = true
+	}
+
+	var problems []string
+	for operation := range registered {
+		if !documented[operation] {
+			problems = append(problems, operation+" is not documented")
+		}
+	}
+	for operation := range documented {
+		if !registered[operation] {
+			problems = append(problems, operation+" is documented but has no route")
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("openapi.json is out of date: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+//Filename: cmd/api/openapi_test.go
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	app := newTestApplication(t)
+
+	err := checkOpenAPI(openAPISpec, app.endpoints())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckOpenAPIReportsDrift(t *testing.T) {
+	spec := []byte(`{"paths": {
+		"/v1/todos/{id}": {"parameters": [], "get": {}, "delete": {}}
+	}}`)
+
+	endpoints := []route{
+		{http.MethodGet, "/v1/todos/:id", nil},
+		{http.MethodPost, "/v1/todos", nil},
+	}
+
+	err := checkOpenAPI(spec, endpoints)
+	if err == nil {
+		t.Fatal("no error for a spec that doesn't match the routes")
+	}
+
+	for _, want := range []string{
+		"POST /v1/todos is not documented",
+		"DELETE /v1/todos/{id} is documented but has no route",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q doesn't report %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+//Filename: cmd/api/pages.go
+
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"todo.imerlopez.net/internal/data"
+	"todo.imerlopez.net/internal/validator"
+)
+
+// the pages of the HTML interface, each one is rendered inside base.tmpl
+//
+//go:embed templates
+var templateFiles embed.FS
+
+// the number of todo tasks on a page of the HTML list
+const pageSize = 20
+
+// templateData holds everything a page may show
+type templateData struct {
+	CSRFToken string
+	Flash     string
+	Todo      *data.Todo        //the task being edited or the values of the new task form
+	Errors    map[string]string //failed checks of the form
+	Todos     []*data.Todo
+	Metadata  data.Metadata
+	Search    url.Values //the list query, kept in the pagination links
+	Status    int
+	Message   string
+}
+
+// functions available to the templates
+var templateFuncs = template.FuncMap{
+	"add": func(a, b int) int {
+		return a + b
+	},
+	"date": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04")
+	},
+	"page": func(search url.Values, page int) string {
+		qs := url.Values{}
+		for key, values := range search {
+			qs[key] = values
+		}
+		qs.Set("page", strconv.Itoa(page))
+		return "/todos?" + qs.Encode()
+	},
+}
+
+// loadPages() parses every page together with the base layout
+func loadPages() (map[string]*template.Template, error) {
+	names, err := fs.Glob(templateFiles, "templates/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make(map[string]*template.Template)
+
+	for _, name := range names {
+		if path.Base(name) == "base.tmpl" {
+			continue
+		}
+
+		page, err := template.New(path.Base(name)).Funcs(templateFuncs).ParseFS(templateFiles, "templates/base.tmpl", name)
+		if err != nil {
+			return nil, err
+		}
+
+		pages[path.Base(name)] = page
+	}
+
+	return pages, nil
+}
+
+// render() writes a page with the session's CSRF token and pending flash message.
+// The page is rendered to a buffer first so that a template error can still be
+// answered with a server error
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, name string, td *templateData) {
+	s, err := app.readSession(r)
+	if err != nil {
+		app.serverErrorPage(w, r, err)
+		return
+	}
+
+	td.CSRFToken = s.CSRFToken
+	td.Flash = s.Flash
+	s.Flash = ""
+
+	page, ok := app.pages[name]
+	if !ok {
+		app.serverErrorPage(w, r, fmt.Errorf("the page %s does not exist", name))
+		return
+	}
+
+	var buf bytes.Buffer
+
+	err = page.ExecuteTemplate(&buf, "base", td)
+	if err != nil {
+		app.serverErrorPage(w, r, err)
+		return
+	}
+
+	err = app.writeSession(w, s)
+	if err != nil {
+		app.serverErrorPage(w, r, err)
+		return
+	}
+
+	//the pages carry the CSRF token of the session
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// redirect() sends the browser to url after a form was handled, the flash message
+// is shown on the next page
+func (app *application) redirect(w http.ResponseWriter, r *http.Request, url string, flash string) {
+	s, err := app.readSession(r)
+	if err != nil {
+		app.serverErrorPage(w, r, err)
+		return
+	}
+
+	s.Flash = flash
+
+	err = app.writeSession(w, s)
+	if err != nil {
+		app.serverErrorPage(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// readForm() parses a submitted form and checks its CSRF token, reporting
+// whether the handler should go on
+func (app *application) readForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
+
+	err := r.ParseForm()
+	if err != nil {
+		app.errorPage(w, r, http.StatusBadRequest, "the form could not be read")
+		return false
+	}
+
+	s, err := app.readSession(r)
+	if err != nil {
+		app.serverErrorPage(w, r, err)
+		return false
+	}
+
+	if !s.validCSRFToken(r.PostForm.Get("csrf_token")) {
+		app.errorPage(w, r, http.StatusForbidden, "the form has expired, go back, reload the page and try again")
+		return false
+	}
+
+	return true
+}
+
+// errorPage() renders an error for the HTML interface
+func (app *application) errorPage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	app.render(w, r, status, "error.tmpl", &templateData{Status: status, Message: message})
+}
+
+// serverErrorPage() logs err and tells the user something went wrong. It doesn't go
+// through render(), which is where many of these errors come from
+func (app *application) serverErrorPage(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+	http.Error(w, "the server encountered a problem and couldn't process the request", http.StatusInternalServerError)
+}
+
+// pageTodo() fetches the task named by the id of a page, answering not found when there
+// is no such task
+func (app *application) pageTodo(w http.ResponseWriter, r *http.Request) (*data.Todo, bool) {
+	todo, err := app.readTodo(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorPage(w, r, http.StatusNotFound, "the task couldn't be found")
+		default:
+			app.serverErrorPage(w, r, err)
+		}
+		return nil, false
+	}
+
+	return todo, true
+}
+
+// todoListPage() renders the list of todo tasks with the new task form, the
+// query is validated the same way as listTodosHandler()
+func (app *application) todoListPage(w http.ResponseWriter, r *http.Request, status int, td *templateData) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	search := app.readTodoSearch(qs, v)
+
+	filters := data.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: pageSize,
+		Sort:     app.readString(qs, "sort", "-created_at"),
+		SortList: data.TodoSortList,
+	}
+
+	td.Search = url.Values{}
+	for _, key := range []string{"title", "sort"} {
+		if qs.Has(key) {
+			td.Search.Set(key, qs.Get(key))
+		}
+	}
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		td.Errors = v.Errors
+		app.render(w, r, http.StatusUnprocessableEntity, "todos.tmpl", td)
+		return
+	}
+
+	todos, metadata, err := app.models.Todos.GetAll(search, filters)
+	if err != nil {
+		app.serverErrorPage(w, r, err)
+		return
+	}
+
+	td.Todos = todos
+	td.Metadata = metadata
+
+	app.render(w, r, status, "todos.tmpl", td)
+}
+
+// GET /todos lists the todo tasks
+func (app *application) listTodosPage(w http.ResponseWriter, r *http.Request) {
+	app.todoListPage(w, r, http.StatusOK, &templateData{Todo: &data.Todo{}})
+}
+
+// POST /todos creates a todo task from the new task form
+func (app *application) createTodoPage(w http.ResponseWriter, r *http.Request) {
+	if !app.readForm(w, r) {
+		return
+	}
+
+	todo := &data.Todo{
+		Title:       r.PostForm.Get("title"),
+		Description: r.PostForm.Get("description"),
+		Completed:   r.PostForm.Get("completed") != "",
+	}
+
+	problems, err := app.insertTodo(todo)
+	if err != nil {
+		app.serverErrorPage(w, r, err)
+		return
+	}
+
+	//show the list again with the form filled in and the errors next to the fields
+	if problems != nil {
+		app.todoListPage(w, r, http.StatusUnprocessableEntity, &templateData{Todo: todo, Errors: problems})
+		return
+	}
+
+	app.redirect(w, r, "/todos", fmt.Sprintf("Created %q", todo.Title))
+}
+
+// GET /todos/:id shows the edit form of a todo task
+func (app *application) editTodoPage(w http.ResponseWriter, r *http.Request) {
+	todo, ok := app.pageTodo(w, r)
+	if !ok {
+		return
+	}
+
+	app.render(w, r, http.StatusOK, "todo.tmpl", &templateData{Todo: todo})
+}
+
+// POST /todos/:id saves the edit form of a todo task
+func (app *application) updateTodoPage(w http.ResponseWriter, r *http.Request) {
+	if !app.readForm(w, r) {
+		return
+	}
+
+	todo, ok := app.pageTodo(w, r)
+	if !ok {
+		return
+	}
+
+	todo.Title = r.PostForm.Get("title")
+	todo.Description = r.PostForm.Get("description")
+	todo.Completed = r.PostForm.Get("completed") != ""
+
+	problems, err := app.saveTodo(todo)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.redirect(w, r, "/todos", "The task was changed or deleted while you were editing it, please try again")
+		default:
+			app.serverErrorPage(w, r, err)
+		}
+		return
+	}
+
+	if problems != nil {
+		app.render(w, r, http.StatusUnprocessableEntity, "todo.tmpl", &templateData{Todo: todo, Errors: problems})
+		return
+	}
+
+	app.redirect(w, r, "/todos", fmt.Sprintf("Saved %q", todo.Title))
+}
+
+// POST /todos/:id/delete deletes a todo task, forms can't send DELETE
+func (app *application) deleteTodoPage(w http.ResponseWriter, r *http.Request) {
+	if !app.readForm(w, r) {
+		return
+	}
+
+	todo, ok := app.pageTodo(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Todos.Delete(todo.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorPage(w, r, err)
+		return
+	}
+
+	app.redirect(w, r, "/todos", fmt.Sprintf("Deleted %q", todo.Title))
+}
@@ -0,0 +1,175 @@
+//Filename: cmd/api/patch.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"todo.imerlopez.net/internal/data"
+)
+
+// the PATCH bodies that are applied to the JSON representation of a todo task
+const (
+	mergePatchType = "application/merge-patch+json" // RFC 7396
+	jsonPatchType  = "application/json-patch+json"  // RFC 6902
+)
+
+var (
+	// errBadPatch means the patch document itself couldn't be read
+	errBadPatch = errors.New("body contains a badly-formed patch")
+	// errPatchTestFailed means a test operation of a JSON Patch didn't hold
+	errPatchTestFailed = errors.New("a test operation of the patch failed")
+	// errPatchNotApplied means the patch doesn't fit the todo task, e.g. it removes a missing member
+	errPatchNotApplied = errors.New("the patch can't be applied to the todo task")
+)
+
+// patchFieldsError lists the members of a patched todo task that are wrong
+type patchFieldsError map[string]string
+
+func (e patchFieldsError) Error() string {
+	return "the patched todo task has invalid fields"
+}
+
+// patchType() returns the patch media type of the request, or "" for a plain
+// JSON update
+func patchType(r *http.Request) string {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	switch mediaType {
+	case mergePatchType, jsonPatchType:
+		return mediaType
+	default:
+		return ""
+	}
+}
+
+// readPatch() applies the patch in the request body to the JSON representation
+// of todo. Only title, description and completed may change, the rest can be
+// used by test operations to make the update conditional
+func (app *application) readPatch(w http.ResponseWriter, r *http.Request, mediaType string, todo *data.Todo) error {
+	maxBytes := 1_048_576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return fmt.Errorf("%w: body must not larger than %d bytes", errBadPatch, maxBytes)
+		}
+		return err
+	}
+
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return fmt.Errorf("%w: body must not be empty", errBadPatch)
+	}
+
+	doc, err := json.Marshal(todo)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+
+	switch mediaType {
+	case mergePatchType:
+		patched, err = jsonpatch.MergePatch(doc, patch)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadPatch, err)
+		}
+
+	case jsonPatchType:
+		operations, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errBadPatch, err)
+		}
+
+		patched, err = operations.Apply(doc)
+		switch {
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			return errPatchTestFailed
+		case err != nil:
+			return fmt.Errorf("%w: %v", errPatchNotApplied, err)
+		}
+	}
+
+	return applyPatchedTodo(todo, patched)
+}
+
+// applyPatchedTodo() copies the editable fields of the patched document into
+// todo after checking that nothing else changed
+func applyPatchedTodo(todo *data.Todo, patched []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+
+	var result data.Todo
+
+	err := dec.Decode(&result)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		switch {
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			return patchFieldsError{unmarshalTypeError.Field: fmt.Sprintf("must be a JSON %s", jsonKind(unmarshalTypeError.Type.Kind().String()))}
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+			return patchFieldsError{field: "is not a field of a todo task"}
+		default:
+			return fmt.Errorf("%w: %v", errPatchNotApplied, err)
+		}
+	}
+
+	problems := patchFieldsError{}
+
+	if result.PublicID != todo.PublicID {
+		problems["id"] = "can't be changed"
+	}
+	if !result.CreatedAt.Equal(todo.CreatedAt) {
+		problems["created_at"] = "can't be changed"
+	}
+	if !result.UpdatedAt.Equal(todo.UpdatedAt) {
+		problems["updated_at"] = "can't be changed"
+	}
+	if result.Version != todo.Version {
+		problems["version"] = "can't be changed, it goes up with every change"
+	}
+	if (result.CompletedAt == nil) != (todo.CompletedAt == nil) ||
+		(result.CompletedAt != nil && !result.CompletedAt.Equal(*todo.CompletedAt)) {
+		problems["completed_at"] = "can't be changed, it follows completed"
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	//a removed or null member is cleared
+	todo.Title = result.Title
+	todo.Description = result.Description
+	todo.Completed = result.Completed
+
+	return nil
+}
+
+// jsonKind() names the JSON type a Go kind is decoded from
+func jsonKind(kind string) string {
+	switch kind {
+	case "bool":
+		return "boolean"
+	case "int64", "int", "float64":
+		return "number"
+	case "struct", "map":
+		return "object"
+	default:
+		return kind
+	}
+}
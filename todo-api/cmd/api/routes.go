@@ -4,22 +4,125 @@ package main
 
 import (
 	"net/http"
+	"path"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 )
 
+// a route is an endpoint of the API, each one is documented in openapi.json
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+}
+
+// endpoints() lists every route of the API
+func (app *application) endpoints() []route {
+	return []route{
+		{http.MethodGet, "/v1/healthcheck", app.healthcheckHandler},
+		{http.MethodGet, "/v1/openapi.json", app.openAPIHandler},
+		{http.MethodPost, "/v1/todos", app.idempotent(app.createTodoHandler)},
+		{http.MethodPost, "/v1/todos/import", app.importTodosHandler},
+		{http.MethodGet, "/v1/todos/:id", app.showTodoHandler},
+		{http.MethodGet, "/v1/todos/stream", app.streamTodosHandler},
+		{http.MethodGet, "/v1/todos/export", app.exportTodosHandler},
+		{http.MethodPut, "/v1/todos/:id", app.replaceTodoHandler},
+		{http.MethodPatch, "/v1/todos/:id", app.updateTodoHandler},
+		{http.MethodDelete, "/v1/todos/:id", app.deleteTodoHandler},
+		{http.MethodGet, "/v1/todos", app.listTodosHandler},
+		{http.MethodGet, "/v1/todos.ics", app.icsFeedHandler},
+		{http.MethodGet, "/v1/sync", app.pullSyncHandler},
+		{http.MethodPost, "/v1/sync", app.pushSyncHandler},
+		{http.MethodGet, "/v1/ws", app.websocketHandler},
+	}
+}
+
 func (app *application) routes() http.Handler {
 	//create router
 	router := httprouter.New()
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/todos", app.createTodoHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/todos/:id", app.showTodoHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/todos/:id", app.updateTodoHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/todos/:id", app.deleteTodoHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/todos", app.listTodosHandler)
+
+	endpoints := app.endpoints()
+
+	//static paths next to a :id wildcard of the same method, e.g. GET
+	///v1/todos/stream, are dispatched by the wildcard route
+	named := make(map[string]map[string]http.HandlerFunc)
+	for _, rt := range endpoints {
+		parent, name := path.Split(rt.path)
+		if !strings.HasPrefix(name, ":") && app.hasRoute(endpoints, rt.method, parent+":id") {
+			if named[rt.method+parent] == nil {
+				named[rt.method+parent] = make(map[string]http.HandlerFunc)
+			}
+			named[rt.method+parent][name] = rt.handler
+		}
+	}
+
+	for _, rt := range endpoints {
+		parent, name := path.Split(rt.path)
+		handlers := named[rt.method+parent]
+
+		switch {
+		case handlers == nil:
+			router.HandlerFunc(rt.method, rt.path, rt.handler)
+		case name == ":id":
+			router.HandlerFunc(rt.method, rt.path, app.subresources(rt.handler, handlers))
+		}
+	}
+
+	//WebDAV methods such as PROPFIND can't be described by OpenAPI, so the
+	//CalDAV server is routed here rather than in endpoints()
+	for _, method := range davMethods {
+		router.HandlerFunc(method, "/dav/*path", app.caldavHandler)
+		router.HandlerFunc(method, "/.well-known/caldav", app.caldavRedirectHandler)
+	}
+
+	//the server rendered pages for browsers without JavaScript, HTML forms
+	//only send GET and POST
+	router.HandlerFunc(http.MethodGet, "/todos", app.listTodosPage)
+	router.HandlerFunc(http.MethodPost, "/todos", app.createTodoPage)
+	router.HandlerFunc(http.MethodGet, "/todos/:id", app.editTodoPage)
+	router.HandlerFunc(http.MethodPost, "/todos/:id", app.updateTodoPage)
+	router.HandlerFunc(http.MethodPost, "/todos/:id/delete", app.deleteTodoPage)
+
+	//every other path belongs to the UI when it is enabled, without it the
+	//site opens on the server rendered list
+	if app.ui != nil {
+		router.NotFound = http.HandlerFunc(app.uiHandler)
+	} else {
+		router.Handler(http.MethodGet, "/", http.RedirectHandler("/todos", http.StatusSeeOther))
+	}
+
+	if app.config.compression.enabled {
+		return app.compress(router)
+	}
 
 	return router
 
 }
+
+// httprouter doesn't allow static segments next to the :id wildcard, so the
+// named endpoints under /v1/todos/ are dispatched here before falling back to next
+func (app *application) subresources(next http.HandlerFunc, handlers map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := handlers[params.ByName("id")]; ok {
+			handler(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// hasRoute() reports whether endpoints contains the method and path
+func (app *application) hasRoute(endpoints []route, method, path string) bool {
+	for _, rt := range endpoints {
+		if rt.method == method && rt.path == path {
+			return true
+		}
+	}
+	return false
+}
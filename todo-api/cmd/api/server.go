@@ -26,6 +26,11 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	//close open event streams, shutdown would wait on them until its deadline otherwise
+	srv.RegisterOnShutdown(app.events.close)
+	//websocket connections are hijacked and not tracked by the server at all
+	srv.RegisterOnShutdown(app.hub.close)
+
 	//shutdown func should return its errors to its channel
 
 	shutdownError := make(chan error)
@@ -0,0 +1,98 @@
+//Filename: cmd/api/session.go
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// the cookie holding the session of the HTML interface
+const sessionCookie = "todo_session"
+
+// a session is kept in a signed cookie, the server stores nothing
+type session struct {
+	CSRFToken string `json:"csrf_token"`
+	Flash     string `json:"flash,omitempty"`
+}
+
+// newSessionSecret() returns a random key for signing sessions, used when the server
+// is started without -session-secret. Sessions don't survive a restart then
+func newSessionSecret() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// readSession() returns the session of the request. A missing or tampered cookie
+// gets a new session with a fresh CSRF token
+func (app *application) readSession(r *http.Request) (*session, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err == nil {
+		payload, signature, ok := strings.Cut(cookie.Value, ".")
+
+		if ok && hmac.Equal([]byte(signature), []byte(app.signSession(payload))) {
+			js, err := base64.RawURLEncoding.DecodeString(payload)
+			if err == nil {
+				var s session
+				if json.Unmarshal(js, &s) == nil && s.CSRFToken != "" {
+					return &s, nil
+				}
+			}
+		}
+	}
+
+	token := make([]byte, 32)
+
+	_, err = rand.Read(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session{CSRFToken: base64.RawURLEncoding.EncodeToString(token)}, nil
+}
+
+// writeSession() stores the session in the response cookie
+func (app *application) writeSession(w http.ResponseWriter, s *session) error {
+	js, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(js)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    payload + "." + app.signSession(payload),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// signSession() computes the HMAC of a cookie payload
+func (app *application) signSession(payload string) string {
+	mac := hmac.New(sha256.New, []byte(app.config.session.secret))
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// validCSRFToken() compares the token of a submitted form with the one in the session
+func (s *session) validCSRFToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) == 1
+}
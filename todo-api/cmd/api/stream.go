@@ -0,0 +1,269 @@
+//Filename: cmd/api/stream.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"todo.imerlopez.net/internal/data"
+)
+
+// number of events read from the database per query when catching up
+const eventsPageSize = 100
+
+// the broker fans out todo events to every connected stream
+type broker struct {
+	mu          sync.Mutex
+	subscribers map[chan *data.Event]struct{}
+	closed      bool
+}
+
+func newBroker() *broker {
+	return &broker{
+		subscribers: make(map[chan *data.Event]struct{}),
+	}
+}
+
+// subscribe() registers a new listener. The returned channel is closed when the
+// broker shuts down or when the listener falls too far behind
+func (b *broker) subscribe() chan *data.Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan *data.Event, 64)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe() removes a listener that is no longer interested in events
+func (b *broker) unsubscribe(ch chan *data.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish() hands the event to every listener without blocking. Slow listeners
+// are dropped, they can resume from their last event id
+func (b *broker) publish(event *data.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// close() disconnects every listener so that shutdown isn't held up by open streams
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+	b.closed = true
+}
+
+// listenForEvents() waits for the event store to announce changes and
+// publishes the new events to the broker and the websocket hub. It runs for
+// the lifetime of the server
+func (app *application) listenForEvents() {
+
+	wake, err := app.models.Events.Listen(func(err error) {
+		app.logger.PrintError(err, nil)
+	})
+	if err != nil {
+		//streams keep working, only with the delay of the periodic check below
+		app.logger.PrintError(err, nil)
+	}
+
+	//start from the newest event, streams replay anything older themselves
+	lastID, err := app.models.Events.Latest()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+
+	for {
+		select {
+		case <-wake:
+		//catch up regularly in case a notification was lost
+		case <-time.After(90 * time.Second):
+		}
+
+		lastID = app.publishEventsSince(lastID)
+	}
+}
+
+// publishEventsSince() publishes every event after lastID and returns the id of
+// the last event published. GetSince() stops at the last settled event, so no
+// event can turn up behind lastID later. Events held back that way are picked up
+// on the next wake up, at the latest by the regular catch up
+func (app *application) publishEventsSince(lastID int64) int64 {
+	for {
+		events, err := app.models.Events.GetSince(lastID, eventsPageSize)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return lastID
+		}
+
+		for _, event := range events {
+			app.events.publish(event)
+			app.hub.publish(event)
+			lastID = event.ID
+		}
+
+		if len(events) < eventsPageSize {
+			return lastID
+		}
+	}
+}
+
+// the stream handler sends todo changes to the client as server-sent events
+func (app *application) streamTodosHandler(w http.ResponseWriter, r *http.Request) {
+
+	//read the id of the last event the client saw, EventSource sends it as a
+	//header when reconnecting and the query string allows setting it initially
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var lastSent int64
+
+	if lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || id < 0 {
+			app.badRequestResponse(w, r, errors.New("invalid last event id"))
+			return
+		}
+		lastSent = id
+	}
+
+	//subscribe before reading the backlog so that no event is missed in between
+	events := app.events.subscribe()
+	defer app.events.unsubscribe(events)
+
+	//without an event id the client is only interested in new changes
+	if lastEventID == "" {
+		latest, err := app.models.Events.Latest()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		lastSent = latest
+	}
+
+	//the stream stays open far longer than the server's write timeout allows
+	rc := http.NewResponseController(w)
+
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	//replay the events the client missed
+	for {
+		backlog, err := app.models.Events.GetSince(lastSent, eventsPageSize)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		for _, event := range backlog {
+			err = writeEvent(w, event)
+			if err != nil {
+				return
+			}
+			lastSent = event.ID
+		}
+
+		if len(backlog) < eventsPageSize {
+			break
+		}
+	}
+
+	err = rc.Flush()
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	//keep idle connections from being closed by proxies
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			//skip events that were already sent from the backlog
+			if event.ID <= lastSent {
+				continue
+			}
+
+			err = writeEvent(w, event)
+			if err != nil {
+				return
+			}
+			lastSent = event.ID
+
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+		}
+
+		err = rc.Flush()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent() writes a todo event in the server-sent events format
+func writeEvent(w http.ResponseWriter, event *data.Event) error {
+	js, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, js)
+
+	return err
+}
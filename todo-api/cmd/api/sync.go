@@ -0,0 +1,368 @@
+//Filename: cmd/api/sync.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"todo.imerlopez.net/internal/data"
+	"todo.imerlopez.net/internal/validator"
+)
+
+// limits of the sync protocol, clients keep pulling while more is true
+const (
+	syncPageSize   = 500
+	maxSyncChanges = 500
+)
+
+// the outcome of a change pushed by a client
+const (
+	syncApplied  = "applied"
+	syncConflict = "conflict"
+	syncInvalid  = "invalid"
+)
+
+// a syncChange is a todo task created, changed or removed since the sync token
+// the client sent. Deleted tasks are tombstones without a todo
+type syncChange struct {
+	ID      string     `json:"id"`
+	Deleted bool       `json:"deleted"`
+	Todo    *data.Todo `json:"todo,omitempty"`
+}
+
+// a syncResult tells the client what became of one of the changes it pushed.
+// On a conflict todo holds the current state of the task, or deleted is set
+// when it no longer exists
+type syncResult struct {
+	ID      string            `json:"id"`
+	Status  string            `json:"status"`
+	Deleted bool              `json:"deleted,omitempty"`
+	Todo    *data.Todo        `json:"todo,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// pullSyncHandler returns the changes made to todo tasks after the sync token in
+// since. The token is the id of the last event the client has seen, without one
+// the client gets every todo task, a page at a time. Clients store the returned
+// sync_token and send it back on the next pull
+func (app *application) pullSyncHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	limit := app.readInt(qs, "limit", syncPageSize, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= syncPageSize, "limit", fmt.Sprintf("must be a maximum of %d", syncPageSize))
+
+	var since, after int64
+	if value := qs.Get("since"); value != "" {
+		var ok bool
+		since, after, ok = parseSyncToken(value)
+		v.Check(ok, "since", "must be a sync token returned by the server")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	//the latest event is read first, anything changed while the todo tasks
+	//are read is sent again on the next pull
+	latest, err := app.models.Events.Latest()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if since > latest {
+		app.failedValidationResponse(w, r, map[string]string{"since": "must be a sync token returned by the server"})
+		return
+	}
+
+	//a snapshot keeps the token of its first page, changes made while the
+	//client pages through it come with the pulls after it
+	if after > 0 {
+		app.syncSnapshot(w, r, since, after, limit)
+		return
+	}
+	if since == 0 {
+		app.syncSnapshot(w, r, latest, 0, limit)
+		return
+	}
+
+	events, err := app.models.Events.GetSince(since, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	//a task changed several times is sent once, as it is now
+	changes := []*syncChange{}
+	seen := make(map[string]int)
+	token := since
+
+	for _, event := range events {
+		token = event.ID
+
+		change := &syncChange{ID: event.TodoPublicID, Todo: event.Todo}
+		if event.Type == data.EventDeleted || event.Todo == nil {
+			change.Deleted = true
+			change.Todo = nil
+		}
+
+		//events recorded before todo tasks had public ids can't be matched
+		//by the client
+		if change.ID == "" {
+			continue
+		}
+
+		if i, ok := seen[change.ID]; ok {
+			changes[i] = change
+			continue
+		}
+
+		seen[change.ID] = len(changes)
+		changes = append(changes, change)
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{
+		"changes":    changes,
+		"sync_token": strconv.FormatInt(token, 10),
+		"more":       len(events) == limit,
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// syncSnapshot() sends a page of the todo tasks after the one with id after to
+// a client syncing for the first time. While there are more the sync token
+// carries both the event the snapshot started at and the last task sent
+func (app *application) syncSnapshot(w http.ResponseWriter, r *http.Request, event, after int64, limit int) {
+	todos, err := app.models.Todos.GetAfter(after, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	changes := []*syncChange{}
+	for _, todo := range todos {
+		changes = append(changes, &syncChange{ID: todo.PublicID, Todo: todo})
+	}
+
+	more := len(todos) == limit
+
+	token := strconv.FormatInt(event, 10)
+	if more {
+		token = fmt.Sprintf("%d.%d", event, todos[len(todos)-1].ID)
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{
+		"changes":    changes,
+		"sync_token": token,
+		"more":       more,
+	}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// parseSyncToken() reads a sync token, the id of the last event the client has
+// seen. Tokens handed out part way through a snapshot add the id of the last
+// todo task sent, as in 42.1000
+func parseSyncToken(value string) (event, after int64, ok bool) {
+	eventPart, afterPart, snapshot := strings.Cut(value, ".")
+
+	event, err := strconv.ParseInt(eventPart, 10, 64)
+	if err != nil || event < 0 {
+		return 0, 0, false
+	}
+
+	if !snapshot {
+		return event, 0, true
+	}
+
+	after, err = strconv.ParseInt(afterPart, 10, 64)
+	if err != nil || after < 1 {
+		return 0, 0, false
+	}
+
+	return event, after, true
+}
+
+// pushSyncHandler applies a batch of changes made by a client while it was offline.
+// Every change names the version of the todo task it was made to, changes made
+// to an older version are conflicts and are left for the client to resolve.
+// The response has no sync token, changes made by others since the client's
+// last pull only reach it when it pulls from its own token
+func (app *application) pushSyncHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Changes []struct {
+			ID          string `json:"id" xml:"id"`
+			Version     int32  `json:"version" xml:"version"`
+			Title       string `json:"title" xml:"title"`
+			Description string `json:"description" xml:"description"`
+			Completed   bool   `json:"completed" xml:"completed"`
+			Deleted     bool   `json:"deleted" xml:"deleted"`
+		} `json:"changes" xml:"changes>change"`
+	}
+
+	err := app.readRequest(w, r, &input)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUnsupportedMediaType):
+			app.unsupportedMediaTypeResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(input.Changes) > maxSyncChanges {
+		app.failedValidationResponse(w, r, map[string]string{"changes": fmt.Sprintf("must not contain more than %d items", maxSyncChanges)})
+		return
+	}
+
+	//the changes are applied in order, a failure part way through leaves the
+	//earlier ones in place and the client pushes the batch again
+	results := []*syncResult{}
+
+	for _, change := range input.Changes {
+		todo := &data.Todo{
+			Version:     change.Version,
+			Title:       change.Title,
+			Description: change.Description,
+			Completed:   change.Completed,
+		}
+
+		result, err := app.applySyncChange(change.ID, todo, change.Deleted)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		results = append(results, result)
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// applySyncChange() stores a single pushed change. A version of zero creates the
+// todo task under the id chosen by the client. The id is kept as the client
+// wrote it, so that it can match the results to its own records
+func (app *application) applySyncChange(id string, todo *data.Todo, deleted bool) (*syncResult, error) {
+	result := &syncResult{ID: id}
+
+	if _, ok := data.ParsePublicID(id); !ok {
+		result.Status = syncInvalid
+		result.Errors = map[string]string{"id": "must be a ULID or a UUID"}
+		return result, nil
+	}
+
+	if todo.Version < 0 {
+		result.Status = syncInvalid
+		result.Errors = map[string]string{"version": "must not be negative"}
+		return result, nil
+	}
+
+	current, err := app.models.Todos.GetByPublicID(id)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	switch {
+	//removing a task that is already gone is done
+	case deleted && current == nil:
+		result.Status = syncApplied
+		result.Deleted = true
+
+	case current == nil && todo.Version > 0:
+		result.Status = syncConflict
+		result.Deleted = true
+
+	case current == nil:
+		if !app.config.todos.clientIDs {
+			result.Status = syncInvalid
+			result.Errors = map[string]string{"id": "todo tasks can't be created under client ids"}
+			return result, nil
+		}
+
+		todo.PublicID = id
+		problems, err := app.insertTodo(todo)
+		switch {
+		case errors.Is(err, data.ErrDuplicatePublicID):
+			//created by another request since the lookup
+			return app.syncConflict(result)
+		case err != nil:
+			return nil, err
+		case problems != nil:
+			result.Status = syncInvalid
+			result.Errors = problems
+		default:
+			result.Status = syncApplied
+			result.Todo = todo
+		}
+
+	case current.Version != todo.Version:
+		result.Status = syncConflict
+		result.Todo = current
+
+	case deleted:
+		err = app.models.Todos.DeleteVersion(current.ID, current.Version)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			//changed or deleted by another request since the lookup
+			return app.syncConflict(result)
+		case err != nil:
+			return nil, err
+		}
+
+		result.Status = syncApplied
+		result.Deleted = true
+
+	default:
+		current.Title = todo.Title
+		current.Description = todo.Description
+		current.Completed = todo.Completed
+
+		problems, err := app.saveTodo(current)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			return app.syncConflict(result)
+		case err != nil:
+			return nil, err
+		case problems != nil:
+			result.Status = syncInvalid
+			result.Errors = problems
+		default:
+			result.Status = syncApplied
+			result.Todo = current
+		}
+	}
+
+	return result, nil
+}
+
+// syncConflict() reports a change that lost a race with another request, along
+// with the todo task as it is now or as deleted
+func (app *application) syncConflict(result *syncResult) (*syncResult, error) {
+	result.Status = syncConflict
+
+	todo, err := app.models.Todos.GetByPublicID(result.ID)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		result.Deleted = true
+	case err != nil:
+		return nil, err
+	default:
+		result.Todo = todo
+	}
+
+	return result, nil
+}
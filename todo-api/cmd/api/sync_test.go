@@ -0,0 +1,213 @@
+//Filename: cmd/api/sync_test.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"todo.imerlopez.net/internal/data"
+)
+
+// a syncPull is the body of GET /v1/sync
+type syncPull struct {
+	Changes []struct {
+		ID      string `json:"id"`
+		Deleted bool   `json:"deleted"`
+	} `json:"changes"`
+	SyncToken string `json:"sync_token"`
+	More      bool   `json:"more"`
+}
+
+func TestSyncPushKeepsOthersChanges(t *testing.T) {
+	app := newTestApplication(t)
+	mine := seedTodo(t, app, "errands")
+
+	var pull syncPull
+	app.request(t, http.MethodGet, "/v1/sync", "", nil).decode(t, &pull)
+
+	if len(pull.Changes) != 1 {
+		t.Fatalf("first pull has %d changes, want 1", len(pull.Changes))
+	}
+
+	//another client creates a task, then this one pushes its change
+	theirs := seedTodo(t, app, "birthday")
+
+	res := app.request(t, http.MethodPost, "/v1/sync",
+		`{"changes":[{"id":"`+mine.PublicID+`","version":1,"title":"errands","description":"milk","completed":true}]}`, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("push status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+
+	var push struct {
+		Results []syncResult `json:"results"`
+	}
+	res.decode(t, &push)
+
+	if len(push.Results) != 1 || push.Results[0].Status != syncApplied || push.Results[0].Todo.Version != 2 {
+		t.Fatalf("push results %+v, want one applied change at version 2", push.Results)
+	}
+
+	//pulling from the earlier token returns both changes
+	var next syncPull
+	app.request(t, http.MethodGet, "/v1/sync?since="+pull.SyncToken, "", nil).decode(t, &next)
+
+	seen := make(map[string]bool)
+	for _, change := range next.Changes {
+		seen[change.ID] = true
+	}
+
+	if !seen[theirs.PublicID] || !seen[mine.PublicID] || len(next.Changes) != 2 {
+		t.Errorf("pull after push returned %+v, want %s and %s", next.Changes, theirs.PublicID, mine.PublicID)
+	}
+}
+
+func TestSyncPushConflicts(t *testing.T) {
+	app := newTestApplication(t)
+	todo := seedTodo(t, app, "errands")
+
+	body := `{"changes":[
+		{"id":"` + todo.PublicID + `","version":1,"title":"errands","description":"milk"},
+		{"id":"` + todo.PublicID + `","version":1,"title":"stale","description":"milk"},
+		{"id":"3f2504e0-4f89-11d3-9a0c-0305e82c3301","version":0,"title":"offline","description":"new"},
+		{"id":"3f2504e0-4f89-11d3-9a0c-0305e82c3302","version":4,"title":"gone","description":"deleted"},
+		{"id":"not an id","version":0,"title":"x","description":"x"}
+	]}`
+
+	res := app.request(t, http.MethodPost, "/v1/sync", body, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+
+	var push struct {
+		Results []syncResult `json:"results"`
+	}
+	res.decode(t, &push)
+
+	want := []string{syncApplied, syncConflict, syncApplied, syncConflict, syncInvalid}
+	if len(push.Results) != len(want) {
+		t.Fatalf("%d results, want %d", len(push.Results), len(want))
+	}
+
+	for i, result := range push.Results {
+		if result.Status != want[i] {
+			t.Errorf("change %d is %s, want %s", i, result.Status, want[i])
+		}
+	}
+
+	if todo := push.Results[1].Todo; todo == nil || todo.Version != 2 {
+		t.Errorf("the conflict doesn't carry the current task: %+v", todo)
+	}
+	if !push.Results[3].Deleted {
+		t.Error("the conflict on a missing task isn't marked deleted")
+	}
+}
+
+func TestSyncDeleteIsVersioned(t *testing.T) {
+	app := newTestApplication(t)
+	todo := seedTodo(t, app, "errands")
+	version := todo.Version
+
+	//another client changes the task after this one read it
+	todo.Completed = true
+	err := app.models.Todos.Update(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Todos.DeleteVersion(todo.ID, version)
+	if !errors.Is(err, data.ErrEditConflict) {
+		t.Fatalf("deleting an older version: got %v, want ErrEditConflict", err)
+	}
+
+	//the push names the version it read, the delete doesn't go through
+	res := app.request(t, http.MethodPost, "/v1/sync",
+		fmt.Sprintf(`{"changes":[{"id":"%s","version":%d,"deleted":true}]}`, todo.PublicID, version), nil)
+
+	var push struct {
+		Results []syncResult `json:"results"`
+	}
+	res.decode(t, &push)
+
+	if len(push.Results) != 1 || push.Results[0].Status != syncConflict || push.Results[0].Todo == nil {
+		t.Fatalf("push results %+v, want a conflict with the current task", push.Results)
+	}
+
+	if _, err := app.models.Todos.GetByPublicID(todo.PublicID); err != nil {
+		t.Errorf("the task is gone: %v", err)
+	}
+}
+
+func TestSyncPushKeepsClientID(t *testing.T) {
+	app := newTestApplication(t)
+	id := "3F2504E0-4F89-11D3-9A0C-0305E82C3301"
+
+	res := app.request(t, http.MethodPost, "/v1/sync",
+		`{"changes":[{"id":"`+id+`","version":0,"title":"offline","description":"new"}]}`, nil)
+
+	var push struct {
+		Results []syncResult `json:"results"`
+	}
+	res.decode(t, &push)
+
+	if len(push.Results) != 1 || push.Results[0].Status != syncApplied {
+		t.Fatalf("push results %+v, want one applied change", push.Results)
+	}
+	if got := push.Results[0].ID; got != id {
+		t.Errorf("result id %q, want %q", got, id)
+	}
+	if todo := push.Results[0].Todo; todo == nil || todo.PublicID != id {
+		t.Errorf("the task is stored as %+v, want id %q", todo, id)
+	}
+
+	//later changes find the task whatever the case
+	res = app.request(t, http.MethodPost, "/v1/sync",
+		`{"changes":[{"id":"`+strings.ToLower(id)+`","version":1,"title":"offline","description":"changed"}]}`, nil)
+	res.decode(t, &push)
+
+	if len(push.Results) != 1 || push.Results[0].Status != syncApplied {
+		t.Errorf("push results %+v, want one applied change", push.Results)
+	}
+}
+
+func TestSyncSnapshotIsPaged(t *testing.T) {
+	app := newTestApplication(t)
+	first := seedTodo(t, app, "errands")
+	seedTodo(t, app, "birthday")
+	last := seedTodo(t, app, "laundry")
+
+	var page syncPull
+	app.request(t, http.MethodGet, "/v1/sync?limit=2", "", nil).decode(t, &page)
+
+	if len(page.Changes) != 2 || !page.More {
+		t.Fatalf("first page has %d changes and more %v, want 2 and true", len(page.Changes), page.More)
+	}
+
+	//a task already sent changes while the client pages through the snapshot
+	first.Completed = true
+	err := app.models.Todos.Update(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var next syncPull
+	app.request(t, http.MethodGet, "/v1/sync?limit=2&since="+page.SyncToken, "", nil).decode(t, &next)
+
+	if len(next.Changes) != 1 || next.Changes[0].ID != last.PublicID || next.More {
+		t.Fatalf("second page %+v, want only %s and no more", next, last.PublicID)
+	}
+	if strings.Contains(next.SyncToken, ".") {
+		t.Fatalf("the last page hands out the snapshot token %q", next.SyncToken)
+	}
+
+	//the change made during the snapshot comes with the next pull
+	var changed syncPull
+	app.request(t, http.MethodGet, "/v1/sync?since="+next.SyncToken, "", nil).decode(t, &changed)
+
+	if len(changed.Changes) != 1 || changed.Changes[0].ID != first.PublicID {
+		t.Errorf("pull after the snapshot returned %+v, want %s", changed.Changes, first.PublicID)
+	}
+}
@@ -0,0 +1,100 @@
+//Filename: cmd/api/testutils_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"todo.imerlopez.net/internal/data"
+	"todo.imerlopez.net/internal/jsonlog"
+)
+
+// newTestApplication() returns an application backed by the in-memory store,
+// with the defaults of the command line flags that matter to the handlers
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	pages, err := loadPages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config
+	cfg.env = "testing"
+	cfg.todos.clientIDs = true
+	cfg.todos.numericIDs = true
+	cfg.session.secret = "test-session-secret-of-32-bytes!"
+
+	return &application{
+		config: cfg,
+		logger: jsonlog.New(io.Discard, jsonlog.LevelOff),
+		models: data.NewMemoryModels(),
+		events: newBroker(),
+		hub:    newHub(),
+		pages:  pages,
+	}
+}
+
+// a testResponse is the recorded response to a request sent to the routes
+type testResponse struct {
+	status  int
+	headers http.Header
+	body    []byte
+}
+
+// request() sends a request to the application's routes. Bodies are sent as JSON
+// unless a Content-Type header says otherwise
+func (app *application) request(t *testing.T, method, target, body string, headers map[string]string) testResponse {
+	t.Helper()
+
+	var reader io.Reader
+	if body != "" {
+		reader = bytes.NewBufferString(body)
+	}
+
+	r := httptest.NewRequest(method, target, reader)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, r)
+
+	return testResponse{
+		status:  rr.Code,
+		headers: rr.Header(),
+		body:    rr.Body.Bytes(),
+	}
+}
+
+// decode() reads the JSON body of a response into dst
+func (res testResponse) decode(t *testing.T, dst interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(res.body, dst)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", res.body, err)
+	}
+}
+
+// seedTodo() stores a todo task straight in the models
+func seedTodo(t *testing.T, app *application, title string) *data.Todo {
+	t.Helper()
+
+	todo := &data.Todo{Title: title, Description: "a task"}
+
+	err := app.models.Todos.Insert(todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return todo
+}
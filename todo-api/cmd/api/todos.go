@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"todo.imerlopez.net/internal/data"
 	"todo.imerlopez.net/internal/validator"
@@ -18,17 +19,22 @@ func (app *application) createTodoHandler(w http.ResponseWriter, r *http.Request
 	//our target decode destination
 
 	var input struct {
-		Title       string `json:"title"`
-		Description string `json:"description"`
-		Completed   bool   `json:"completed"`
+		Title       string `json:"title" xml:"title"`
+		Description string `json:"description" xml:"description"`
+		Completed   bool   `json:"completed" xml:"completed"`
 	}
 
 	//initialize the new json.decoder instance
 
-	err := app.readJSON(w, r, &input)
+	err := app.readRequest(w, r, &input)
 
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		switch {
+		case errors.Is(err, errUnsupportedMediaType):
+			app.unsupportedMediaTypeResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
 		return
 	}
 
@@ -39,29 +45,26 @@ func (app *application) createTodoHandler(w http.ResponseWriter, r *http.Request
 		Completed:   input.Completed,
 	}
 
-	//initialize a new validator instance
-
-	v := validator.New()
-
-	//check the map to determine if there were any validation errors
-	if data.ValidateTodo(v, todo); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-
-	//create todo task
-	err = app.models.Todos.Insert(todo)
+	//validate and create todo task
+	problems, err := app.insertTodo(todo)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
 
+	if problems != nil {
+		app.failedValidationResponse(w, r, problems)
+		return
 	}
 
-	//create a location header for newly created resource: todo task
+	//create a location header for newly created resource: todo task, Locations
+	//was sent before and stays for the clients that read it
 	headers := make(http.Header)
-	headers.Set("Locations", fmt.Sprintf("/v1/todos/%d", todo.ID))
+	headers.Set("Location", "/v1/todos/"+todo.PublicID)
+	headers.Set("Locations", "/v1/todos/"+todo.PublicID)
 
 	//write json response
-	err = app.writeJSON(w, http.StatusCreated, envelope{"todo": todo}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"todo": todo}, headers)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -72,15 +75,8 @@ func (app *application) createTodoHandler(w http.ResponseWriter, r *http.Request
 // get todo task by id
 func (app *application) showTodoHandler(w http.ResponseWriter, r *http.Request) {
 
-	//read id parameter
-	id, err := app.readIdParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
-	}
-
-	//fetch the specifc todo tasks
-	todo, err := app.models.Todos.Get(id)
+	//fetch the specifc todo task by its public id
+	todo, err := app.readTodo(r)
 
 	//handler errors
 	if err != nil {
@@ -90,10 +86,31 @@ func (app *application) showTodoHandler(w http.ResponseWriter, r *http.Request)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
+
+		return
+	}
+
+	//the ETag depends on the media type, which is negotiated first
+	env := envelope{"todo": todo}
+
+	enc := app.negotiate(w, r, env)
+	if enc == nil {
+		return
+	}
+
+	//answer conditional requests from clients that already have this version
+	etag, err := etagFor(todo)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.notModified(w, r, representationETag(etag, enc), todo.UpdatedAt) {
+		return
 	}
 
 	//write json data return by get
-	err = app.writeJSON(w, http.StatusOK, envelope{"todo": todo}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, env, nil)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, nil)
@@ -105,15 +122,8 @@ func (app *application) showTodoHandler(w http.ResponseWriter, r *http.Request)
 // todo task update handler
 func (app *application) updateTodoHandler(w http.ResponseWriter, r *http.Request) {
 
-	//get id from parameter
-	id, err := app.readIdParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
-	}
-
-	//fetch record from the db
-	todo, err := app.models.Todos.Get(id)
+	//fetch record from the db by its public id
+	todo, err := app.readTodo(r)
 
 	//handler errors
 	if err != nil {
@@ -129,53 +139,170 @@ func (app *application) updateTodoHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	//create an input struct to hold data read in from client
-	//update input struct by pointer
+	//If-Match makes the update only apply to the version the client has
+	etag, err := etagFor(todo)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-	var input struct {
-		Title       *string `json:"title"`
-		Description *string `json:"description"`
-		Completed   *bool   `json:"completed"`
+	if !preconditionsHold(r, etag) {
+		app.preconditionFailedResponse(w, r)
+		return
 	}
 
-	//initialize the new json.decoder instance
-	err = app.readJSON(w, r, &input)
+	//merge patches and JSON patches are applied to the JSON representation,
+	//they can clear a field and test the stored values first
+	if mediaType := patchType(r); mediaType != "" {
+		err = app.readPatch(w, r, mediaType, todo)
+		if err != nil {
+			var fieldsError patchFieldsError
+
+			switch {
+			case errors.As(err, &fieldsError):
+				app.failedValidationResponse(w, r, fieldsError)
+			case errors.Is(err, errBadPatch):
+				app.badRequestResponse(w, r, err)
+			case errors.Is(err, errPatchTestFailed):
+				app.patchTestFailedResponse(w, r)
+			case errors.Is(err, errPatchNotApplied):
+				app.patchNotAppliedResponse(w, r, err)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+
+			return
+		}
+	} else {
+		err = app.readTodoUpdate(w, r, todo)
+		if err != nil {
+			switch {
+			case errors.Is(err, errUnsupportedMediaType):
+				app.unsupportedMediaTypeResponse(w, r, err)
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+
+			return
+		}
+	}
+
+	//validate the updated todo task record and pass it to update method
+	problems, err := app.saveTodo(todo)
 
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 
 		return
 	}
 
-	//check for updates
+	if problems != nil {
+		app.failedValidationResponse(w, r, problems)
+		return
+	}
 
-	if input.Title != nil {
-		todo.Title = *input.Title
+	//the new version, for the If-Match of the next write
+	etag, err = etagFor(todo)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	if input.Description != nil {
-		todo.Description = *input.Description
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	//write data by get
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"todo": todo}, headers)
+
+	if err != nil {
+
+		app.serverErrorResponse(w, r, err)
+
 	}
 
-	if input.Completed != nil {
-		todo.Completed = *input.Completed
+}
+
+// replaceTodoHandler() writes a whole todo task, fields missing from the body
+// take their defaults. A task that doesn't exist is created when the id is a
+// UUID or ULID and client ids are allowed. If-Match makes it only overwrite the version
+// the client has, If-None-Match: * makes it only create
+func (app *application) replaceTodoHandler(w http.ResponseWriter, r *http.Request) {
+
+	//fetch record from the db by its public id, nil when there is none
+	todo, err := app.readTodo(r)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	//initialize a new Validator instance
-	v := validator.New()
+	//the current version the preconditions are checked against
+	etag := ""
+	if todo != nil {
+		etag, err = etagFor(todo)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 
-	//check the map to determine if there were any validation errors
-	if data.ValidateTodo(v, todo); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
+	if !preconditionsHold(r, etag) {
+		app.preconditionFailedResponse(w, r)
+		return
+	}
+
+	//numbered ids are handed out by the server, only UUIDs and ULIDs can be created
+	publicID, isPublicID := app.readPublicIDParam(r)
+	if todo == nil && (!isPublicID || !app.config.todos.clientIDs) {
+		app.notFoundResponse(w, r)
 		return
 	}
 
-	//Pass the updated todo task record to update method
-	err = app.models.Todos.Update(todo)
+	var input struct {
+		Title       string `json:"title" xml:"title"`
+		Description string `json:"description" xml:"description"`
+		Completed   bool   `json:"completed" xml:"completed"`
+	}
 
+	err = app.readRequest(w, r, &input)
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrEditConflict):
+		case errors.Is(err, errUnsupportedMediaType):
+			app.unsupportedMediaTypeResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if todo == nil {
+		todo = &data.Todo{PublicID: publicID}
+		status = http.StatusCreated
+	}
+
+	todo.Title = input.Title
+	todo.Description = input.Description
+	todo.Completed = input.Completed
+
+	var problems map[string]string
+	if status == http.StatusCreated {
+		problems, err = app.insertTodo(todo)
+	} else {
+		problems, err = app.saveTodo(todo)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatePublicID) && r.Header.Get("If-None-Match") != "":
+			//another request created the task first
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict), errors.Is(err, data.ErrDuplicatePublicID):
 			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -184,41 +311,134 @@ func (app *application) updateTodoHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	//write data by get
-
-	err = app.writeJSON(w, http.StatusOK, envelope{"todo": todo}, nil)
+	if problems != nil {
+		app.failedValidationResponse(w, r, problems)
+		return
+	}
 
+	//the new version, for the If-Match of the next write
+	etag, err = etagFor(todo)
 	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+	if status == http.StatusCreated {
+		headers.Set("Location", "/v1/todos/"+todo.PublicID)
+	}
+
+	err = app.writeResponse(w, r, status, envelope{"todo": todo}, headers)
+	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readTodoUpdate() applies the fields present in the request body to todo,
+// fields that are missing or null are left as they are
+func (app *application) readTodoUpdate(w http.ResponseWriter, r *http.Request, todo *data.Todo) error {
+	//create an input struct to hold data read in from client
+	//update input struct by pointer
+
+	var input struct {
+		Title       *string `json:"title" xml:"title"`
+		Description *string `json:"description" xml:"description"`
+		Completed   *bool   `json:"completed" xml:"completed"`
+	}
 
+	//initialize the new json.decoder instance
+	err := app.readRequest(w, r, &input)
+	if err != nil {
+		return err
 	}
 
+	//check for updates
+
+	if input.Title != nil {
+		todo.Title = *input.Title
+	}
+
+	if input.Description != nil {
+		todo.Description = *input.Description
+	}
+
+	if input.Completed != nil {
+		todo.Completed = *input.Completed
+	}
+
+	return nil
+}
+
+// insertTodo() validates a new todo task and creates it. The JSON and HTML
+// handlers both go through here, failed checks are returned rather than an error
+func (app *application) insertTodo(todo *data.Todo) (map[string]string, error) {
+	v := validator.New()
+
+	if data.ValidateTodo(v, todo); !v.Valid() {
+		return v.Errors, nil
+	}
+
+	return nil, app.models.Todos.Insert(todo)
+}
+
+// saveTodo() validates a changed todo task and stores it, like insertTodo()
+func (app *application) saveTodo(todo *data.Todo) (map[string]string, error) {
+	v := validator.New()
+
+	if data.ValidateTodo(v, todo); !v.Valid() {
+		return v.Errors, nil
+	}
+
+	return nil, app.models.Todos.Update(todo)
 }
 
 //Delete handler: Delete todo task by id
 
 func (app *application) deleteTodoHandler(w http.ResponseWriter, r *http.Request) {
 
-	//get id
-	id, err := app.readIdParam(r)
+	//find the todo task by its public id
+	todo, err := app.readTodo(r)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+
+		return
+	}
 
+	//If-Match makes the delete only remove the version the client has
+	etag, err := etagFor(todo)
 	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
-		app.notFoundResponse(w, r)
+	if !preconditionsHold(r, etag) {
+		app.preconditionFailedResponse(w, r)
 		return
 	}
 
 	//delete a todo task from the database. Send 404 not found status to client
 	//if no matching record
 
-	err = app.models.Todos.Delete(id)
+	if r.Header.Get("If-Match") != "" {
+		err = app.models.Todos.DeleteVersion(todo.ID, todo.Version)
+	} else {
+		err = app.models.Todos.Delete(todo.ID)
+	}
 
 	//Handler error
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			//changed by another request since it was read
+			app.preconditionFailedResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -227,7 +447,7 @@ func (app *application) deleteTodoHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	//Return 200 status ok to client if record is delete successfull
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "Todo Task SuccessFully Deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "Todo Task SuccessFully Deleted"}, nil)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -240,7 +460,7 @@ func (app *application) listTodosHandler(w http.ResponseWriter, r *http.Request)
 
 	//create input struct for params
 	var input struct {
-		Title string
+		data.TodoSearch
 		data.Filters
 	}
 
@@ -251,8 +471,8 @@ func (app *application) listTodosHandler(w http.ResponseWriter, r *http.Request)
 
 	qs := r.URL.Query()
 
-	//use the help method to extract values
-	input.Title = app.readString(qs, "title", "")
+	//use the help method to extract the title and time ranges
+	input.TodoSearch = app.readTodoSearch(qs, v)
 
 	//get the page info
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
@@ -262,25 +482,43 @@ func (app *application) listTodosHandler(w http.ResponseWriter, r *http.Request)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 
 	//specific the allowed sortValues
-	input.Filters.SortList = []string{"id", "title", "completed", "-id", "-title", "-completed"}
+	input.Filters.SortList = data.TodoSortList
 
 	//check for validation errors
-
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	//get listing of all todos
-	todos, metadata, err := app.models.Todos.GetAll(input.Title, input.Filters)
+	todos, metadata, err := app.models.Todos.GetAll(input.TodoSearch, input.Filters)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	env := envelope{"todos": todos, "metadata": metadata}
+
+	enc := app.negotiate(w, r, env)
+	if enc == nil {
+		return
+	}
+
+	//the list has no Last-Modified date since deleting a todo doesn't move the
+	//newest updated_at, the ETag covers the whole page instead
+	etag, err := etagFor(env)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.notModified(w, r, representationETag(etag, enc), time.Time{}) {
+		return
+	}
+
 	//send json response
-	err = app.writeJSON(w, http.StatusOK, envelope{"todos": todos, "metadata": metadata}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, env, nil)
 
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -0,0 +1,347 @@
+//Filename: cmd/api/todos_test.go
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"todo.imerlopez.net/internal/data"
+)
+
+func TestCreateTodo(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"valid", `{"title":"errands","description":"milk and bread"}`, http.StatusCreated},
+		{"missing title", `{"description":"milk and bread"}`, http.StatusUnprocessableEntity},
+		{"unknown field", `{"title":"errands","description":"milk","owner":"me"}`, http.StatusBadRequest},
+		{"malformed", `{"title":`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			res := app.request(t, http.MethodPost, "/v1/todos", tt.body, nil)
+			if res.status != tt.status {
+				t.Fatalf("status %d, want %d: %s", res.status, tt.status, res.body)
+			}
+
+			if tt.status != http.StatusCreated {
+				return
+			}
+
+			var body struct {
+				Todo data.Todo `json:"todo"`
+			}
+			res.decode(t, &body)
+
+			if _, ok := data.ParsePublicID(body.Todo.PublicID); !ok {
+				t.Errorf("id %q is not a public id", body.Todo.PublicID)
+			}
+			if want := "/v1/todos/" + body.Todo.PublicID; res.headers.Get("Location") != want {
+				t.Errorf("Location %q, want %q", res.headers.Get("Location"), want)
+			}
+			if body.Todo.Version != 1 {
+				t.Errorf("version %d, want 1", body.Todo.Version)
+			}
+		})
+	}
+}
+
+func TestShowTodo(t *testing.T) {
+	app := newTestApplication(t)
+	todo := seedTodo(t, app, "errands")
+
+	tests := []struct {
+		name   string
+		target string
+		status int
+	}{
+		{"public id", "/v1/todos/" + todo.PublicID, http.StatusOK},
+		{"numeric id", "/v1/todos/1", http.StatusOK},
+		{"unknown public id", "/v1/todos/01J9Z3V5G7Q8R2M4N6P8T0W2Y4", http.StatusNotFound},
+		{"unknown numeric id", "/v1/todos/99", http.StatusNotFound},
+		{"not an id", "/v1/todos/errands", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.request(t, http.MethodGet, tt.target, "", nil)
+			if res.status != tt.status {
+				t.Fatalf("status %d, want %d: %s", res.status, tt.status, res.body)
+			}
+
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Todo data.Todo `json:"todo"`
+			}
+			res.decode(t, &body)
+
+			if body.Todo.PublicID != todo.PublicID || body.Todo.Title != "errands" {
+				t.Errorf("got %+v, want %+v", body.Todo, todo)
+			}
+		})
+	}
+}
+
+func TestShowTodoNotModified(t *testing.T) {
+	app := newTestApplication(t)
+	todo := seedTodo(t, app, "errands")
+
+	res := app.request(t, http.MethodGet, "/v1/todos/"+todo.PublicID, "", nil)
+
+	etag := res.headers.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	res = app.request(t, http.MethodGet, "/v1/todos/"+todo.PublicID, "", map[string]string{"If-None-Match": etag})
+	if res.status != http.StatusNotModified {
+		t.Fatalf("status %d, want %d", res.status, http.StatusNotModified)
+	}
+}
+
+func TestUpdateTodo(t *testing.T) {
+	app := newTestApplication(t)
+	todo := seedTodo(t, app, "errands")
+
+	res := app.request(t, http.MethodPatch, "/v1/todos/"+todo.PublicID, `{"completed":true}`, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+
+	var body struct {
+		Todo data.Todo `json:"todo"`
+	}
+	res.decode(t, &body)
+
+	if !body.Todo.Completed || body.Todo.CompletedAt == nil {
+		t.Errorf("got %+v, want a completed task", body.Todo)
+	}
+	if body.Todo.Version != 2 {
+		t.Errorf("version %d, want 2", body.Todo.Version)
+	}
+
+	//the version is only changed by the server
+	res = app.request(t, http.MethodPatch, "/v1/todos/"+todo.PublicID, `{"version":7}`,
+		map[string]string{"Content-Type": "application/merge-patch+json"})
+	if res.status != http.StatusUnprocessableEntity {
+		t.Errorf("status %d, want %d: %s", res.status, http.StatusUnprocessableEntity, res.body)
+	}
+}
+
+func TestUpdateTodoIfMatch(t *testing.T) {
+	app := newTestApplication(t)
+	todo := seedTodo(t, app, "errands")
+	target := "/v1/todos/" + todo.PublicID
+
+	etag := app.request(t, http.MethodGet, target, "", nil).headers.Get("ETag")
+
+	res := app.request(t, http.MethodPatch, target, `{"completed":true}`, map[string]string{"If-Match": etag})
+	if res.status != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+	if res.headers.Get("ETag") == etag {
+		t.Error("the ETag didn't change with the update")
+	}
+
+	//the task changed since the client read it
+	res = app.request(t, http.MethodPatch, target, `{"title":"chores"}`, map[string]string{"If-Match": etag})
+	if res.status != http.StatusPreconditionFailed {
+		t.Errorf("status %d, want %d: %s", res.status, http.StatusPreconditionFailed, res.body)
+	}
+}
+
+func TestReplaceTodoKeepsClientID(t *testing.T) {
+	app := newTestApplication(t)
+	id := "6F1D3C2A-8B4E-4F5A-9C7D-0E1F2A3B4C5D"
+
+	res := app.request(t, http.MethodPut, "/v1/todos/"+id, `{"title":"errands","description":"milk"}`, nil)
+	if res.status != http.StatusCreated {
+		t.Fatalf("status %d, want %d: %s", res.status, http.StatusCreated, res.body)
+	}
+
+	var body struct {
+		Todo data.Todo `json:"todo"`
+	}
+	res.decode(t, &body)
+
+	if body.Todo.PublicID != id {
+		t.Errorf("id %q, want %q as the client sent it", body.Todo.PublicID, id)
+	}
+	if want := "/v1/todos/" + id; res.headers.Get("Location") != want {
+		t.Errorf("Location %q, want %q", res.headers.Get("Location"), want)
+	}
+
+	//the id names the same task in any case
+	res = app.request(t, http.MethodPut, "/v1/todos/"+strings.ToLower(id), `{"title":"chores","description":"milk"}`, nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("lower case: status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+
+	res.decode(t, &body)
+	if body.Todo.PublicID != id || body.Todo.Title != "chores" {
+		t.Errorf("got %+v, want the task under %q", body.Todo, id)
+	}
+}
+
+func TestDeleteTodo(t *testing.T) {
+	app := newTestApplication(t)
+	todo := seedTodo(t, app, "errands")
+
+	res := app.request(t, http.MethodDelete, "/v1/todos/"+todo.PublicID, "", nil)
+	if res.status != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+
+	res = app.request(t, http.MethodGet, "/v1/todos/"+todo.PublicID, "", nil)
+	if res.status != http.StatusNotFound {
+		t.Errorf("status %d after delete, want %d", res.status, http.StatusNotFound)
+	}
+
+	res = app.request(t, http.MethodDelete, "/v1/todos/"+todo.PublicID, "", nil)
+	if res.status != http.StatusNotFound {
+		t.Errorf("status %d for a second delete, want %d", res.status, http.StatusNotFound)
+	}
+}
+
+func TestDeleteTodoIfMatch(t *testing.T) {
+	app := newTestApplication(t)
+	todo := seedTodo(t, app, "errands")
+	target := "/v1/todos/" + todo.PublicID
+
+	etag := app.request(t, http.MethodGet, target, "", nil).headers.Get("ETag")
+	app.request(t, http.MethodPatch, target, `{"completed":true}`, nil)
+
+	res := app.request(t, http.MethodDelete, target, "", map[string]string{"If-Match": etag})
+	if res.status != http.StatusPreconditionFailed {
+		t.Fatalf("stale ETag: status %d, want %d: %s", res.status, http.StatusPreconditionFailed, res.body)
+	}
+
+	etag = app.request(t, http.MethodGet, target, "", nil).headers.Get("ETag")
+
+	res = app.request(t, http.MethodDelete, target, "", map[string]string{"If-Match": etag})
+	if res.status != http.StatusOK {
+		t.Errorf("current ETag: status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+}
+
+func TestListTodos(t *testing.T) {
+	app := newTestApplication(t)
+	seedTodo(t, app, "errands")
+	seedTodo(t, app, "birthday")
+	seedTodo(t, app, "more errands")
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		titles []string
+	}{
+		{"all", "", http.StatusOK, []string{"errands", "birthday", "more errands"}},
+		{"by title", "?title=errands", http.StatusOK, []string{"errands", "more errands"}},
+		{"sorted", "?sort=title", http.StatusOK, []string{"birthday", "errands", "more errands"}},
+		{"paged", "?sort=title&page=2&page_size=2", http.StatusOK, []string{"more errands"}},
+		{"bad sort", "?sort=owner", http.StatusUnprocessableEntity, nil},
+		{"page too large", "?page_size=101", http.StatusUnprocessableEntity, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := app.request(t, http.MethodGet, "/v1/todos"+tt.query, "", nil)
+			if res.status != tt.status {
+				t.Fatalf("status %d, want %d: %s", res.status, tt.status, res.body)
+			}
+
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Todos []data.Todo `json:"todos"`
+			}
+			res.decode(t, &body)
+
+			titles := []string{}
+			for _, todo := range body.Todos {
+				titles = append(titles, todo.Title)
+			}
+
+			if len(titles) != len(tt.titles) {
+				t.Fatalf("got %q, want %q", titles, tt.titles)
+			}
+			for i := range titles {
+				if titles[i] != tt.titles[i] {
+					t.Fatalf("got %q, want %q", titles, tt.titles)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryEventsWakeEveryListener(t *testing.T) {
+	app := newTestApplication(t)
+
+	first, err := app.models.Events.Listen(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := app.models.Events.Listen(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seedTodo(t, app, "errands")
+
+	for i, wake := range []<-chan struct{}{first, second} {
+		select {
+		case <-wake:
+		case <-time.After(time.Second):
+			t.Errorf("listener %d wasn't woken", i+1)
+		}
+	}
+}
+
+func TestShowTodoETagPerMediaType(t *testing.T) {
+	app := newTestApplication(t)
+	todo := seedTodo(t, app, "errands")
+	target := "/v1/todos/" + todo.PublicID
+
+	jsonETag := app.request(t, http.MethodGet, target, "", nil).headers.Get("ETag")
+
+	res := app.request(t, http.MethodGet, target, "", map[string]string{"Accept": "application/xml", "If-None-Match": jsonETag})
+	if res.status != http.StatusOK {
+		t.Fatalf("XML with the JSON ETag: status %d, want %d", res.status, http.StatusOK)
+	}
+
+	xmlETag := res.headers.Get("ETag")
+	if xmlETag == jsonETag {
+		t.Fatalf("XML and JSON share the ETag %s", xmlETag)
+	}
+
+	res = app.request(t, http.MethodGet, target, "", map[string]string{"Accept": "application/xml", "If-None-Match": xmlETag})
+	if res.status != http.StatusNotModified {
+		t.Errorf("XML with its own ETag: status %d, want %d", res.status, http.StatusNotModified)
+	}
+
+	//a media type that can't be sent is refused before the cache is checked
+	res = app.request(t, http.MethodGet, target, "", map[string]string{"Accept": "image/png", "If-None-Match": jsonETag})
+	if res.status != http.StatusNotAcceptable {
+		t.Errorf("unacceptable media type: status %d, want %d", res.status, http.StatusNotAcceptable)
+	}
+
+	//writes accept the ETag of any representation
+	res = app.request(t, http.MethodPut, target, `{"title":"errands","description":"milk"}`, map[string]string{"If-Match": xmlETag})
+	if res.status != http.StatusOK {
+		t.Errorf("PUT with the XML ETag: status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+}
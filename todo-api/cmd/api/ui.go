@@ -0,0 +1,165 @@
+//Filename: cmd/api/ui.go
+
+package main
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// the ui directory is written by `go generate`, from the index.html and todo.css
+// that elm make builds in todo-ui
+//
+//go:generate go run ../uibuild -src ../../../todo-ui -out ui
+//go:embed ui
+var uiFiles embed.FS
+
+// the content codings of the precompressed variants, in order of preference
+var uiEncodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// a uiAsset is one file of the UI with its precompressed variants
+type uiAsset struct {
+	contentType  string
+	cacheControl string
+	variants     map[string][]byte //content coding, "" for the file itself
+	etags        map[string]string
+}
+
+// loadUI() reads the embedded UI into memory, keyed by the path it is served at
+func loadUI() (map[string]*uiAsset, error) {
+	root, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		return nil, err
+	}
+
+	assets := make(map[string]*uiAsset)
+
+	err = fs.WalkDir(root, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		coding, base := "", name
+		for _, enc := range uiEncodings {
+			if strings.HasSuffix(name, enc.ext) {
+				coding, base = enc.name, strings.TrimSuffix(name, enc.ext)
+			}
+		}
+
+		content, err := fs.ReadFile(root, name)
+		if err != nil {
+			return err
+		}
+
+		asset, ok := assets["/"+base]
+		if !ok {
+			contentType := mime.TypeByExtension(path.Ext(base))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+
+			//hashed assets never change, index.html names the current ones
+			cacheControl := "no-cache"
+			if strings.HasPrefix(base, "assets/") {
+				cacheControl = "public, max-age=31536000, immutable"
+			}
+
+			asset = &uiAsset{
+				contentType:  contentType,
+				cacheControl: cacheControl,
+				variants:     make(map[string][]byte),
+				etags:        make(map[string]string),
+			}
+			assets["/"+base] = asset
+		}
+
+		sum := sha256.Sum256(content)
+		asset.variants[coding] = content
+		asset.etags[coding] = `"` + hex.EncodeToString(sum[:16]) + `"`
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return assets, nil
+}
+
+// uiHandler() serves the UI for every path without a route. Paths that aren't
+// files get index.html so that the page can be reloaded at any address, the
+// API and CalDAV trees keep their JSON not found response
+func (app *application) uiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	if name == "/v1" || name == "/dav" || strings.HasPrefix(name, "/v1/") || strings.HasPrefix(name, "/dav/") {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	asset, ok := app.ui[name]
+	if !ok {
+		//a missing file is an error, anything else is a page of the app
+		if path.Ext(name) != "" {
+			app.notFoundResponse(w, r)
+			return
+		}
+		asset = app.ui["/index.html"]
+	}
+
+	coding := asset.encoding(r.Header.Get("Accept-Encoding"))
+
+	w.Header().Set("Content-Type", asset.contentType)
+	w.Header().Set("Cache-Control", asset.cacheControl)
+	w.Header().Set("ETag", asset.etags[coding])
+	if len(asset.variants) > 1 {
+		addVary(w.Header(), "Accept-Encoding")
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, asset.etags[coding]) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	content := asset.variants[coding]
+	if coding != "" {
+		w.Header().Set("Content-Encoding", coding)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method == http.MethodGet {
+		w.Write(content)
+	}
+}
+
+// encoding() picks the preferred precompressed variant the client accepts, ""
+// stands for the uncompressed file
+func (a *uiAsset) encoding(acceptEncoding string) string {
+	accepted := acceptedEncodings(acceptEncoding)
+
+	for _, enc := range uiEncodings {
+		if _, ok := a.variants[enc.name]; ok && encodingQuality(accepted, enc.name) > 0 {
+			return enc.name
+		}
+	}
+
+	return ""
+}
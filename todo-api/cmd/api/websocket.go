@@ -0,0 +1,325 @@
+//Filename: cmd/api/websocket.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"todo.imerlopez.net/internal/data"
+)
+
+const (
+	//time allowed to write a message to the client
+	wsWriteWait = 10 * time.Second
+	//time allowed between pongs from the client
+	wsPongWait = 60 * time.Second
+	//how often pings are sent, must be less than the pong wait
+	wsPingPeriod = (wsPongWait * 9) / 10
+	//largest message accepted from the client
+	wsMaxMessageSize = 4096
+	//messages queued for a client before it is considered too slow
+	wsSendBuffer = 64
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// a wsClient is a single websocket connection registered with the hub
+type wsClient struct {
+	hub  *hub
+	conn *websocket.Conn
+	send chan []byte
+	//topics are only read and written by the hub goroutine
+	topics map[string]bool
+}
+
+// a request received from a client. Problems with the request are reported
+// back to the client through the hub, which owns the send channel
+type wsRequest struct {
+	client *wsClient
+	action string
+	topics []string
+	err    string
+}
+
+// the hub keeps track of the websocket clients and what they are subscribed
+// to. All of its state is owned by the run goroutine
+type hub struct {
+	clients    map[*wsClient]bool
+	register   chan *wsClient
+	unregister chan *wsClient
+	requests   chan wsRequest
+	broadcast  chan *data.Event
+	quit       chan struct{}
+	done       chan struct{}
+}
+
+func newHub() *hub {
+	return &hub{
+		clients:    make(map[*wsClient]bool),
+		register:   make(chan *wsClient),
+		unregister: make(chan *wsClient),
+		requests:   make(chan wsRequest),
+		broadcast:  make(chan *data.Event),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// run() handles the hub's channels until close() is called
+func (h *hub) run() {
+	defer close(h.done)
+
+	for {
+		select {
+		case client := <-h.register:
+			h.clients[client] = true
+
+		case client := <-h.unregister:
+			h.drop(client)
+
+		case req := <-h.requests:
+			if !h.clients[req.client] {
+				continue
+			}
+			if req.err != "" {
+				h.send(req.client, envelope{"type": "error", "error": req.err})
+				continue
+			}
+			for _, topic := range req.topics {
+				if req.action == "unsubscribe" {
+					delete(req.client.topics, topic)
+				} else {
+					req.client.topics[topic] = true
+				}
+			}
+			h.send(req.client, envelope{"type": "subscriptions", "topics": req.client.subscriptions()})
+
+		case event := <-h.broadcast:
+			topics := eventTopics(event)
+			for client := range h.clients {
+				for _, topic := range topics {
+					if client.topics[topic] {
+						h.send(client, envelope{"type": "event", "event": event})
+						break
+					}
+				}
+			}
+
+		case <-h.quit:
+			for client := range h.clients {
+				h.drop(client)
+			}
+			return
+		}
+	}
+}
+
+// send() queues a message for the client, clients that cannot keep up are disconnected
+func (h *hub) send(client *wsClient, message envelope) {
+	js, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	select {
+	case client.send <- js:
+	default:
+		h.drop(client)
+	}
+}
+
+// drop() forgets the client and lets its write pump close the connection
+func (h *hub) drop(client *wsClient) {
+	if h.clients[client] {
+		delete(h.clients, client)
+		close(client.send)
+	}
+}
+
+// publish() hands the event to the hub
+func (h *hub) publish(event *data.Event) {
+	select {
+	case h.broadcast <- event:
+	case <-h.done:
+	}
+}
+
+// close() disconnects every client and stops the hub
+func (h *hub) close() {
+	close(h.quit)
+	<-h.done
+}
+
+// eventTopics() lists the topics an event is delivered to
+func eventTopics(event *data.Event) []string {
+	return []string{"todos", canonicalTopic("todos/" + event.TodoPublicID)}
+}
+
+// validTopic() reports whether a client may subscribe to the topic. Clients can
+// follow the whole todo list with "todos" or a single task with "todos/:id",
+// where :id is the task's public id in any case
+func validTopic(topic string) bool {
+	if topic == "todos" {
+		return true
+	}
+
+	_, ok := data.ParsePublicID(strings.TrimPrefix(topic, "todos/"))
+
+	return strings.HasPrefix(topic, "todos/") && ok
+}
+
+// canonicalTopic() writes the public id of a "todos/:id" topic in its canonical
+// case, so that a subscription matches events whatever case either was sent in
+func canonicalTopic(topic string) string {
+	if publicID, ok := data.ParsePublicID(strings.TrimPrefix(topic, "todos/")); ok && strings.HasPrefix(topic, "todos/") {
+		return "todos/" + publicID
+	}
+
+	return topic
+}
+
+// subscriptions() returns the client's topics, only call it from the hub goroutine
+func (c *wsClient) subscriptions() []string {
+	topics := []string{}
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// readPump() reads subscription requests from the connection until it fails
+func (c *wsClient) readPump() {
+	defer func() {
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var input struct {
+			Action string   `json:"action"`
+			Topics []string `json:"topics"`
+		}
+
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		req := wsRequest{client: c}
+
+		//a malformed message doesn't end the connection
+		err = json.Unmarshal(message, &input)
+		if err != nil {
+			req.err = "message must be a JSON object"
+		} else {
+			req.action = input.Action
+			req.err = validateRequest(input.Action, input.Topics)
+			for _, topic := range input.Topics {
+				req.topics = append(req.topics, canonicalTopic(topic))
+			}
+		}
+
+		select {
+		case c.hub.requests <- req:
+		case <-c.hub.done:
+			return
+		}
+	}
+}
+
+// validateRequest() checks a subscription request and returns a message describing the problem
+func validateRequest(action string, topics []string) string {
+	if action != "subscribe" && action != "unsubscribe" {
+		return "action must be subscribe or unsubscribe"
+	}
+
+	if len(topics) == 0 {
+		return "topics must be provided"
+	}
+
+	for _, topic := range topics {
+		if !validTopic(topic) {
+			return fmt.Sprintf("invalid topic %q", topic)
+		}
+	}
+
+	return ""
+}
+
+// writePump() writes queued messages and pings to the connection
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				//the hub closed the channel
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+				return
+			}
+
+			err := c.conn.WriteMessage(websocket.TextMessage, message)
+			if err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := c.conn.WriteMessage(websocket.PingMessage, nil)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// the websocket handler upgrades the connection and registers it with the hub
+func (app *application) websocketHandler(w http.ResponseWriter, r *http.Request) {
+
+	//the upgrader writes its own error response on failure
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	client := &wsClient{
+		hub:    app.hub,
+		conn:   conn,
+		send:   make(chan []byte, wsSendBuffer),
+		topics: make(map[string]bool),
+	}
+
+	select {
+	case app.hub.register <- client:
+	case <-app.hub.done:
+		conn.Close()
+		return
+	}
+
+	go client.writePump()
+	go client.readPump()
+}
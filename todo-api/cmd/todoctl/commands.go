@@ -0,0 +1,340 @@
+//Filename: cmd/todoctl/commands.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"todo.imerlopez.net/internal/data"
+	"todo.imerlopez.net/internal/validator"
+)
+
+// validationError reports the failed checks of a validator
+type validationError map[string]string
+
+func (e validationError) Error() string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	message := "validation failed:"
+	for _, key := range keys {
+		message += fmt.Sprintf("\n  %s: %s", key, e[key])
+	}
+	return message
+}
+
+// the create command validates and inserts a new todo task
+func (app *application) createCommand(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	title := fs.String("title", "", "Title of the task")
+	description := fs.String("description", "", "Description of the task")
+	completed := fs.Bool("completed", false, "Create the task as completed")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	todo := &data.Todo{
+		Title:       *title,
+		Description: *description,
+		Completed:   *completed,
+	}
+
+	v := validator.New()
+	if data.ValidateTodo(v, todo); !v.Valid() {
+		return validationError(v.Errors)
+	}
+
+	err = app.todos.Insert(todo)
+	if err != nil {
+		return err
+	}
+
+	return app.printTodos([]*data.Todo{todo})
+}
+
+// the list command prints a page of todo tasks
+func (app *application) listCommand(args []string) error {
+	var search data.TodoSearch
+	var filters data.Filters
+
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.StringVar(&search.Title, "title", "", "Only list tasks whose title contains these words")
+	fs.StringVar(&filters.Sort, "sort", "id", "Sort order, prefix with - for descending")
+	fs.IntVar(&filters.Page, "page", 1, "Page number")
+	fs.IntVar(&filters.PageSize, "page-size", 20, "Tasks per page")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	filters.SortList = data.TodoSortList
+
+	v := validator.New()
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		return validationError(v.Errors)
+	}
+
+	todos, metadata, err := app.todos.GetAll(search, filters)
+	if err != nil {
+		return err
+	}
+
+	if app.config.output == "json" {
+		return app.writeJSON(map[string]interface{}{"todos": todos, "metadata": metadata})
+	}
+
+	err = app.printTodos(todos)
+	if err != nil {
+		return err
+	}
+
+	if metadata.TotalRecords > 0 {
+		fmt.Fprintf(app.out, "\npage %d of %d, %d tasks\n", metadata.CurrentPage, metadata.LastPage, metadata.TotalRecords)
+	}
+
+	return nil
+}
+
+// the complete command marks todo tasks as completed
+func (app *application) completeCommand(args []string) error {
+	ids, err := parseIDs(args)
+	if err != nil {
+		return err
+	}
+
+	completed := []*data.Todo{}
+
+	for _, id := range ids {
+		todo, err := app.getTodo(id)
+		if err != nil {
+			return fmt.Errorf("todo %s: %w", id, err)
+		}
+
+		todo.Completed = true
+
+		err = app.todos.Update(todo)
+		if err != nil {
+			return fmt.Errorf("todo %s: %w", id, err)
+		}
+
+		completed = append(completed, todo)
+	}
+
+	return app.printTodos(completed)
+}
+
+// the delete command removes todo tasks
+func (app *application) deleteCommand(args []string) error {
+	ids, err := parseIDs(args)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err = app.deleteTodo(id)
+		if err != nil {
+			return fmt.Errorf("todo %s: %w", id, err)
+		}
+
+		fmt.Fprintf(app.out, "deleted todo %s\n", id)
+	}
+
+	return nil
+}
+
+// the export command writes every todo task as a JSON array
+func (app *application) exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	file := fs.String("file", "", "Write to this file instead of standard output")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	out := app.out
+	if *file != "" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	//write the array one task at a time instead of holding every task in memory
+	_, err = fmt.Fprint(out, "[")
+	if err != nil {
+		return err
+	}
+
+	separator := "\n"
+	filters := data.Filters{Sort: "id", SortList: data.TodoSortList}
+
+	err = app.todos.Export(context.Background(), data.TodoSearch{}, filters, func(todo *data.Todo) error {
+		js, err := json.MarshalIndent(todo, "\t", "\t")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(out, "%s\t%s", separator, js)
+		separator = ",\n"
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprint(out, "\n]\n")
+
+	return err
+}
+
+// the import command creates todo tasks from a JSON array, as written by export.
+// Every task is validated before any of them is created, in one transaction
+func (app *application) importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := fs.String("file", "", "Read from this file instead of standard input")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var input []struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Completed   bool   `json:"completed"`
+	}
+
+	err = json.NewDecoder(in).Decode(&input)
+	if err != nil {
+		return fmt.Errorf("input must be a JSON array of todo tasks: %w", err)
+	}
+
+	todos := []*data.Todo{}
+	problems := validationError{}
+
+	for i, row := range input {
+		todo := &data.Todo{
+			Title:       row.Title,
+			Description: row.Description,
+			Completed:   row.Completed,
+		}
+
+		v := validator.New()
+		data.ValidateTodo(v, todo)
+
+		for key, message := range v.Errors {
+			problems[fmt.Sprintf("row %d %s", i+1, key)] = message
+		}
+
+		todos = append(todos, todo)
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	//all of the tasks are created or none are
+	err = app.todos.InsertMany(todos)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(app.out, "imported %d tasks\n", len(todos))
+
+	return nil
+}
+
+// parseIDs() reads todo ids from the command arguments, public ids are put in
+// the case they are stored in and the old sequential numbers are kept as they are
+func parseIDs(args []string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, errors.New("at least one todo id is required")
+	}
+
+	ids := []string{}
+	for _, arg := range args {
+		if publicID, ok := data.ParsePublicID(arg); ok {
+			ids = append(ids, publicID)
+			continue
+		}
+
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid id %q", arg)
+		}
+		ids = append(ids, arg)
+	}
+
+	return ids, nil
+}
+
+// getTodo() fetches a todo task by an id read by parseIDs()
+func (app *application) getTodo(id string) (*data.Todo, error) {
+	if number, err := strconv.ParseInt(id, 10, 64); err == nil {
+		return app.todos.Get(number)
+	}
+
+	return app.todos.GetByPublicID(id)
+}
+
+// deleteTodo() removes a todo task by an id read by parseIDs()
+func (app *application) deleteTodo(id string) error {
+	if number, err := strconv.ParseInt(id, 10, 64); err == nil {
+		return app.todos.Delete(number)
+	}
+
+	return app.todos.DeleteByPublicID(id)
+}
+
+// printTodos() writes todo tasks in the configured output format
+func (app *application) printTodos(todos []*data.Todo) error {
+	if app.config.output == "json" {
+		return app.writeJSON(todos)
+	}
+
+	tw := tabwriter.NewWriter(app.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tCOMPLETED\tUPDATED\tDESCRIPTION")
+
+	for _, todo := range todos {
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%s\t%s\n",
+			todo.PublicID, todo.Title, todo.Completed, todo.UpdatedAt.Local().Format("2006-01-02 15:04"), todo.Description)
+	}
+
+	return tw.Flush()
+}
+
+// writeJSON() writes indented JSON like the API does
+func (app *application) writeJSON(data interface{}) error {
+	enc := json.NewEncoder(app.out)
+	enc.SetIndent("", "\t")
+	return enc.Encode(data)
+}
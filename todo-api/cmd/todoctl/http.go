@@ -0,0 +1,308 @@
+//Filename: cmd/todoctl/http.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"todo.imerlopez.net/internal/data"
+	"todo.imerlopez.net/pkg/client"
+)
+
+// httpStore implements data.TodoStore against a running todo API. It keeps the
+// ETag of every task it read, updates send it in If-Match so that they only
+// apply to that version, as the version column does for the database stores
+type httpStore struct {
+	client *client.Client
+	etags  map[string]string
+}
+
+func newHTTPStore(baseURL string) *httpStore {
+	return &httpStore{
+		client: client.New(baseURL),
+		etags:  make(map[string]string),
+	}
+}
+
+// mapError() turns client errors into the errors of the data package
+func mapError(err error) error {
+	var failed *client.ValidationError
+	var rejected *client.ImportError
+
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		return data.ErrRecordNotFound
+	case errors.Is(err, client.ErrEditConflict), errors.Is(err, client.ErrPreconditionFailed):
+		return data.ErrEditConflict
+	case errors.As(err, &failed):
+		return validationError(failed.Fields)
+	case errors.As(err, &rejected):
+		problems := validationError{}
+		for _, row := range rejected.Rows {
+			for key, message := range row.Errors {
+				problems[fmt.Sprintf("row %d %s", row.Row, key)] = message
+			}
+		}
+		return problems
+	default:
+		return err
+	}
+}
+
+// fromClient() copies a todo task returned by the API into dst and keeps its
+// ETag, the API only gives out public ids so the number is left at zero
+func (s *httpStore) fromClient(dst *data.Todo, todo *client.Todo) {
+	if todo.ETag != "" {
+		s.etags[todo.ID] = todo.ETag
+	}
+
+	*dst = data.Todo{
+		PublicID:    todo.ID,
+		CreatedAt:   todo.CreatedAt,
+		Title:       todo.Title,
+		Description: todo.Description,
+		Completed:   todo.Completed,
+		UpdatedAt:   todo.UpdatedAt,
+		CompletedAt: todo.CompletedAt,
+		Version:     todo.Version,
+	}
+}
+
+func (s *httpStore) Insert(todo *data.Todo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	created, err := s.client.CreateTodo(ctx, client.NewTodo{
+		Title:       todo.Title,
+		Description: todo.Description,
+		Completed:   todo.Completed,
+	})
+	if err != nil {
+		return mapError(err)
+	}
+
+	s.fromClient(todo, created)
+
+	return nil
+}
+
+// InsertMany() uploads the tasks to the import endpoint, which creates them in one transaction
+func (s *httpStore) InsertMany(todos []*data.Todo) error {
+	input := make([]client.NewTodo, len(todos))
+	for i, todo := range todos {
+		input[i] = client.NewTodo{Title: todo.Title, Description: todo.Description, Completed: todo.Completed}
+	}
+
+	js, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	result, err := s.client.ImportTodos(ctx, client.FormatJSON, "todos.json", bytes.NewReader(js), false)
+	if err != nil {
+		return mapError(err)
+	}
+
+	if len(result.Todos) != len(todos) {
+		return fmt.Errorf("the API created %d of %d tasks", len(result.Todos), len(todos))
+	}
+
+	for i, created := range result.Todos {
+		s.fromClient(todos[i], created)
+	}
+
+	return nil
+}
+
+func (s *httpStore) Get(id int64) (*data.Todo, error) {
+	if id < 1 {
+		return nil, data.ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	found, err := s.client.GetTodo(ctx, strconv.FormatInt(id, 10))
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	var todo data.Todo
+	s.fromClient(&todo, found)
+	todo.ID = id
+
+	return &todo, nil
+}
+
+func (s *httpStore) GetByPublicID(publicID string) (*data.Todo, error) {
+	if publicID == "" {
+		return nil, data.ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	found, err := s.client.GetTodo(ctx, publicID)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	var todo data.Todo
+	s.fromClient(&todo, found)
+
+	return &todo, nil
+}
+
+// Update() sends every field, the API keeps its own timestamps. It fails with
+// data.ErrEditConflict when the task changed since it was read
+func (s *httpStore) Update(todo *data.Todo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id := todo.PublicID
+	if id == "" {
+		id = strconv.FormatInt(todo.ID, 10)
+	}
+
+	//a task that wasn't read through the store must still have its version
+	etag, ok := s.etags[todo.PublicID]
+	if !ok {
+		current, err := s.client.GetTodo(ctx, id)
+		if err != nil {
+			return mapError(err)
+		}
+
+		if current.Version != todo.Version {
+			return data.ErrEditConflict
+		}
+
+		etag = current.ETag
+	}
+
+	updated, err := s.client.UpdateTodo(ctx, id, client.TodoUpdate{
+		Title:       client.String(todo.Title),
+		Description: client.String(todo.Description),
+		Completed:   client.Bool(todo.Completed),
+		IfMatch:     etag,
+	})
+	if err != nil {
+		return mapError(err)
+	}
+
+	number := todo.ID
+	s.fromClient(todo, updated)
+	todo.ID = number
+
+	return nil
+}
+
+func (s *httpStore) Delete(id int64) error {
+	if id < 1 {
+		return data.ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return mapError(s.client.DeleteTodo(ctx, strconv.FormatInt(id, 10)))
+}
+
+// DeleteVersion() fetches the task to check its version and deletes it with the
+// ETag of that version in If-Match, a change made in between isn't lost
+func (s *httpStore) DeleteVersion(id int64, version int32) error {
+	if id < 1 {
+		return data.ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	current, err := s.client.GetTodo(ctx, strconv.FormatInt(id, 10))
+	if err != nil {
+		return mapError(err)
+	}
+
+	if current.Version != version {
+		return data.ErrEditConflict
+	}
+
+	return mapError(s.client.DeleteTodoIfMatch(ctx, current.ID, current.ETag))
+}
+
+func (s *httpStore) DeleteByPublicID(publicID string) error {
+	if publicID == "" {
+		return data.ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return mapError(s.client.DeleteTodo(ctx, publicID))
+}
+
+func (s *httpStore) GetAll(search data.TodoSearch, filters data.Filters) ([]*data.Todo, data.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	found, metadata, err := s.client.ListTodos(ctx, client.Filters{
+		Title:           search.Title,
+		UpdatedSince:    search.UpdatedAt.Since,
+		UpdatedBefore:   search.UpdatedAt.Before,
+		CompletedSince:  search.CompletedAt.Since,
+		CompletedBefore: search.CompletedAt.Before,
+		Sort:            filters.Sort,
+		Page:            filters.Page,
+		PageSize:        filters.PageSize,
+	})
+	if err != nil {
+		return nil, data.Metadata{}, mapError(err)
+	}
+
+	todos := make([]*data.Todo, len(found))
+	for i, todo := range found {
+		todos[i] = &data.Todo{}
+		s.fromClient(todos[i], todo)
+	}
+
+	return todos, data.Metadata(metadata), nil
+}
+
+// Export() walks the pages of the list endpoint, the largest page the API allows is 100
+func (s *httpStore) Export(ctx context.Context, search data.TodoSearch, filters data.Filters, fn func(*data.Todo) error) error {
+	it := s.client.Todos(client.Filters{
+		Title:           search.Title,
+		UpdatedSince:    search.UpdatedAt.Since,
+		UpdatedBefore:   search.UpdatedAt.Before,
+		CompletedSince:  search.CompletedAt.Since,
+		CompletedBefore: search.CompletedAt.Before,
+		Sort:            filters.Sort,
+		PageSize:        100,
+	})
+
+	for it.Next(ctx) {
+		var todo data.Todo
+		s.fromClient(&todo, it.Todo())
+
+		err := fn(&todo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return mapError(it.Err())
+}
+
+// GetAfter() needs the sequential ids, which the API doesn't hand out. No
+// command uses it
+func (s *httpStore) GetAfter(id int64, limit int) ([]*data.Todo, error) {
+	return nil, errors.New("the API doesn't page todo tasks by id")
+}
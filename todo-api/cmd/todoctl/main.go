@@ -0,0 +1,112 @@
+//Filename: cmd/todoctl/main.go
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"todo.imerlopez.net/internal/data"
+)
+
+const usage = `usage: todoctl [flags] <command> [command flags]
+
+Commands:
+  create    create a todo task
+  list      list todo tasks
+  complete  mark a todo task as completed
+  delete    delete a todo task
+  export    write every todo task as JSON
+  import    create todo tasks from a JSON array
+
+Flags:
+`
+
+// Config Settings
+type config struct {
+	api    string // base URL of the API, the database is used when empty
+	output string // table, json
+	db     struct {
+		driver string
+		dsn    string
+	}
+}
+
+//Dependency Injection
+
+type application struct {
+	config config
+	todos  data.TodoStore
+	out    io.Writer
+}
+
+func main() {
+	var cfg config
+
+	flag.StringVar(&cfg.api, "api", os.Getenv("TODO_API_URL"), "Base URL of the todo API, e.g. http://localhost:4000 (uses the database when empty)")
+	flag.StringVar(&cfg.output, "output", "table", "Output format: table, json")
+	flag.StringVar(&cfg.db.driver, "db-driver", "postgres", "Database driver: postgres, sqlite")
+	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("TODO_DB_DSN"), "PostgreSQL DSN or SQLite database file")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(cfg, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "todoctl:", err)
+		os.Exit(1)
+	}
+}
+
+// run() connects to the configured backend and executes the command
+func run(cfg config, args []string) error {
+	if cfg.output != "table" && cfg.output != "json" {
+		return fmt.Errorf("unknown output format %q", cfg.output)
+	}
+
+	app := &application{
+		config: cfg,
+		out:    os.Stdout,
+	}
+
+	if cfg.api != "" {
+		app.todos = newHTTPStore(cfg.api)
+	} else {
+		db, err := data.OpenDB(cfg.db.driver, cfg.db.dsn)
+		if err != nil {
+			return err
+		}
+
+		defer db.Close()
+
+		app.todos = data.NewDatabaseModels(db, cfg.db.driver, cfg.db.dsn).Todos
+	}
+
+	commands := map[string]func(args []string) error{
+		"create":   app.createCommand,
+		"list":     app.listCommand,
+		"complete": app.completeCommand,
+		"delete":   app.deleteCommand,
+		"export":   app.exportCommand,
+		"import":   app.importCommand,
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		return errors.New("unknown command " + args[0] + ", run todoctl -h for help")
+	}
+
+	return command(args[1:])
+}
@@ -0,0 +1,141 @@
+//Filename: cmd/uibuild/main.go
+
+// uibuild copies the compiled todo-ui into cmd/api/ui so that it can be embedded
+// in the API binary. The script elm make inlines into index.html is moved to its
+// own file, the script and stylesheet are renamed after a hash of their content
+// and every file gets gzip and brotli compressed variants
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/andybalholm/brotli"
+)
+
+// the inline script written by elm make, it's the only one in the page
+var inlineScript = regexp.MustCompile(`(?s)<script>\n(.*)</script>`)
+
+func main() {
+	src := flag.String("src", "../todo-ui", "Directory holding the index.html and todo.css built by elm make")
+	out := flag.String("out", "cmd/api/ui", "Directory to write the embedded files to, it is emptied first")
+	flag.Parse()
+
+	err := build(*src, *out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "uibuild: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func build(src, out string) error {
+	page, err := os.ReadFile(filepath.Join(src, "index.html"))
+	if err != nil {
+		return err
+	}
+
+	css, err := os.ReadFile(filepath.Join(src, "todo.css"))
+	if err != nil {
+		return err
+	}
+
+	match := inlineScript.FindSubmatchIndex(page)
+	if match == nil {
+		return errors.New("index.html has no inline script, was it built by elm make?")
+	}
+	js := page[match[2]:match[3]]
+
+	files := map[string][]byte{
+		hashedName("todo", ".js", js):   js,
+		hashedName("todo", ".css", css): css,
+	}
+
+	//point the page at the hashed files
+	var rewritten []byte
+	rewritten = append(rewritten, page[:match[0]]...)
+	rewritten = append(rewritten, fmt.Sprintf(`<script src="/%s"></script>`, hashedName("todo", ".js", js))...)
+	rewritten = append(rewritten, page[match[1]:]...)
+
+	link := []byte(`href="todo.css"`)
+	if !bytes.Contains(rewritten, link) {
+		return errors.New("index.html doesn't link todo.css")
+	}
+	rewritten = bytes.Replace(rewritten, link, []byte(fmt.Sprintf(`href="/%s"`, hashedName("todo", ".css", css))), 1)
+
+	files["index.html"] = rewritten
+
+	err = os.RemoveAll(out)
+	if err != nil {
+		return err
+	}
+
+	for name, content := range files {
+		err = writeVariants(filepath.Join(out, filepath.FromSlash(name)), content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hashedName() returns assets/<base>.<hash><ext>, the hash changes with the content
+// so the file can be cached forever
+func hashedName(base, ext string, content []byte) string {
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf("assets/%s.%s%s", base, hex.EncodeToString(sum[:])[:12], ext)
+}
+
+// writeVariants() writes the file with its .gz and .br variants, a variant that
+// isn't smaller than the file is left out
+func writeVariants(name string, content []byte) error {
+	err := os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(name, content, 0o644)
+	if err != nil {
+		return err
+	}
+
+	var gz bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	zw.Write(content)
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+
+	var br bytes.Buffer
+	bw := brotli.NewWriterLevel(&br, brotli.BestCompression)
+	bw.Write(content)
+	err = bw.Close()
+	if err != nil {
+		return err
+	}
+
+	for ext, compressed := range map[string][]byte{".gz": gz.Bytes(), ".br": br.Bytes()} {
+		if len(compressed) >= len(content) {
+			continue
+		}
+
+		err = os.WriteFile(name+ext, compressed, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
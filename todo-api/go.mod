@@ -1,8 +1,22 @@
 module todo.imerlopez.net
 
-go 1.19
+go 1.22
 
 require (
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.7
 )
+
+require github.com/gorilla/websocket v1.5.0
+
+require github.com/mattn/go-sqlite3 v1.14.16
+
+require (
+	github.com/andybalholm/brotli v1.0.5
+	github.com/evanphx/json-patch/v5 v5.9.11
+	github.com/klauspost/compress v1.18.0
+	github.com/oklog/ulid/v2 v2.1.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -0,0 +1,78 @@
+//Filename: internal/data/db.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// OpenDB() opens a connection pool for the driver, postgres or sqlite, and
+// checks that the database can be reached
+func OpenDB(driver, dsn string) (*sql.DB, error) {
+	var db *sql.DB
+	var err error
+
+	switch driver {
+	case "postgres":
+		db, err = sql.Open("postgres", dsn)
+	case "sqlite":
+		//wait for locks instead of failing, let readers run alongside the writer
+		//and take the write lock as soon as a transaction begins
+		if dsn == "" {
+			dsn = "todo.db"
+		}
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		db, err = sql.Open("sqlite3", dsn+separator+"_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//create a context with a 5 sec timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	//title search needs FTS5, which go-sqlite3 only includes with a build tag
+	if driver == "sqlite" {
+		var fts5 bool
+		err = db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		if !fts5 {
+			db.Close()
+			return nil, errors.New("SQLite was built without FTS5, rebuild with -tags sqlite_fts5")
+		}
+	}
+
+	return db, nil
+}
+
+// NewDatabaseModels create the models for a pool opened with OpenDB()
+func NewDatabaseModels(db *sql.DB, driver, dsn string) Models {
+	if driver == "sqlite" {
+		return NewSQLiteModels(db)
+	}
+	return NewModels(db, dsn)
+}
@@ -0,0 +1,196 @@
+//Filename: internal/data/events.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// the channel the todo_notify trigger announces new events on
+const EventsChannel = "todo_events"
+
+// the kinds of changes recorded in the todo_events table
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+)
+
+// Event describes a single change made to a todo task. Todo holds the
+// current state of the task and is nil for deleted tasks, clients know the
+// task by TodoPublicID
+type Event struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Type         string    `json:"type"`
+	TodoID       int64     `json:"-"`
+	TodoPublicID string    `json:"todo_id"`
+	Todo         *Todo     `json:"todo,omitempty"`
+}
+
+//Define an EventModel which wrap a sql.DB connection pool
+
+type EventModel struct {
+	DB  *sql.DB
+	DSN string
+}
+
+// Latest() returns the id of the most recent settled event or zero if there are
+// none. Events written by transactions still running may get lower ids, see GetSince()
+func (m EventModel) Latest() (int64, error) {
+
+	query :=
+		`
+		SELECT COALESCE(MAX(id), 0) FROM todo_events
+		WHERE horizon <= pg_snapshot_xmin(pg_current_snapshot())
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	//cleanup to prevent memory leak
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query).Scan(&id)
+
+	return id, err
+}
+
+// GetSince() returns up to limit events recorded after the event with the given id
+// in the order they happened. Ids are drawn before transactions commit, so events
+// are only read up to the last settled one: one whose horizon, the next transaction
+// id when it was written, is older than every running transaction. No event below
+// it can turn up later, and the last id read is a cursor that never skips an event
+func (m EventModel) GetSince(id int64, limit int) ([]*Event, error) {
+
+	//join the todo table so that listeners receive the task itself
+	query :=
+		`
+		SELECT e.id, e.created_at, e.op, e.todo_id, COALESCE(e.todo_public_id, ''),
+			t.id, t.created_at, t.title, t.description, t.completed, t.updated_at, t.completed_at, t.public_id, t.version
+		FROM todo_events e
+		LEFT JOIN todo t ON t.id = e.todo_id AND e.op <> 'deleted'
+		WHERE e.id > $1 AND e.id <= (
+			SELECT COALESCE(MAX(id), 0) FROM todo_events
+			WHERE horizon <= pg_snapshot_xmin(pg_current_snapshot())
+		)
+		ORDER BY e.id ASC LIMIT $2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	//close the result set
+	defer rows.Close()
+
+	events := []*Event{}
+
+	for rows.Next() {
+		var event Event
+
+		//the todo columns are null for deleted tasks
+		var (
+			todoID      sql.NullInt64
+			createdAt   sql.NullTime
+			title       sql.NullString
+			description sql.NullString
+			completed   sql.NullBool
+			updatedAt   sql.NullTime
+			completedAt sql.NullTime
+			publicID    sql.NullString
+			version     sql.NullInt32
+		)
+
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.Type,
+			&event.TodoID,
+			&event.TodoPublicID,
+			&todoID,
+			&createdAt,
+			&title,
+			&description,
+			&completed,
+			&updatedAt,
+			&completedAt,
+			&publicID,
+			&version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if todoID.Valid {
+			event.Todo = &Todo{
+				ID:          todoID.Int64,
+				CreatedAt:   createdAt.Time,
+				Title:       title.String,
+				Description: description.String,
+				Completed:   completed.Bool,
+				UpdatedAt:   updatedAt.Time,
+				PublicID:    publicID.String,
+				Version:     version.Int32,
+			}
+
+			if completedAt.Valid {
+				event.Todo.CompletedAt = &completedAt.Time
+			}
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Listen() opens a dedicated connection that LISTENs on the events channel.
+// Reconnects are reported as a wake up as well since notifications may have been missed
+func (m EventModel) Listen(onError func(error)) (<-chan struct{}, error) {
+
+	listener := pq.NewListener(m.DSN, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			onError(err)
+		}
+	})
+
+	err := listener.Listen(EventsChannel)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	wake := make(chan struct{}, 1)
+
+	go func() {
+		for {
+			select {
+			case <-listener.Notify:
+			case <-time.After(90 * time.Second):
+				//check the connection is still alive
+				go listener.Ping()
+				continue
+			}
+
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return wake, nil
+}
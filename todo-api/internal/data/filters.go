@@ -5,10 +5,18 @@ package data
 import (
 	"math"
 	"strings"
+	"time"
 
 	"todo.imerlopez.net/internal/validator"
 )
 
+// TodoSortList holds the sort values todo tasks can be listed by, a leading
+// minus sorts in descending order
+var TodoSortList = []string{
+	"id", "title", "completed", "created_at", "updated_at", "completed_at",
+	"-id", "-title", "-completed", "-created_at", "-updated_at", "-completed_at",
+}
+
 type Filters struct {
 	Page     int
 	PageSize int
@@ -21,7 +29,7 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than zero")
 	v.Check(f.Page <= 1000, "page", "must be a maximum of 1000")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.Page <= 100, "page_size", "must be a maximum of 100")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
 	//check that the sort params matches a values in the acceptable sort list
 	v.Check(validator.In(f.Sort, f.SortList...), "sort", "invalid sort value")
@@ -78,3 +86,32 @@ func calculateMetadata(totalRecrods int, page int, pageSize int) Metadata {
 		TotalRecords: totalRecrods,
 	}
 }
+
+// TimeRange selects records whose timestamp is at or after Since and before Before.
+// A zero time leaves that end of the range open
+type TimeRange struct {
+	Since  time.Time
+	Before time.Time
+}
+
+// ValidateTimeRange() checks that the range isn't empty, key prefixes the query parameter names
+func ValidateTimeRange(v *validator.Validator, key string, t TimeRange) {
+	if !t.Since.IsZero() && !t.Before.IsZero() {
+		v.Check(t.Before.After(t.Since), key+"_before", "must be later than "+key+"_since")
+	}
+}
+
+// the since() and before() methods return the bounds as query arguments, NULL when open
+func (t TimeRange) since() interface{} {
+	if t.Since.IsZero() {
+		return nil
+	}
+	return t.Since
+}
+
+func (t TimeRange) before() interface{} {
+	if t.Before.IsZero() {
+		return nil
+	}
+	return t.Before
+}
@@ -0,0 +1,166 @@
+//Filename: internal/data/idempotency.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// how long a stored response is replayed before its key can be reused
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// how long a request may hold a key without storing a response, a key left
+// behind by a request that never finished, say the server crashed, is free
+// to be used again after that. Longer than the server's write timeout
+const IdempotencyKeyLease = time.Minute
+
+// IdempotencyKey is the stored outcome of a request sent with an Idempotency-Key
+// header. Status is zero while the original request is still in progress, for
+// at most IdempotencyKeyLease
+type IdempotencyKey struct {
+	Key         string
+	CreatedAt   time.Time
+	Fingerprint string
+	Status      int
+	Headers     map[string][]string
+	Body        []byte
+}
+
+//Define an IdempotencyModel which wrap a sql.DB connection pool
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// Reserve() claims the key for a new request. If the key was already used the
+// stored record is returned instead and the caller must not process the request
+func (m IdempotencyModel) Reserve(key, fingerprint string) (*IdempotencyKey, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	//cleanup to prevent memory leak
+	defer cancel()
+
+	//an expired key or an abandoned reservation is free to be used again
+	query :=
+		`
+		DELETE FROM idempotency_keys
+		WHERE key = $1 AND (created_at < NOW() - make_interval(secs => $2)
+			OR (status = 0 AND created_at < NOW() - make_interval(secs => $3)))
+	`
+
+	_, err := m.DB.ExecContext(ctx, query, key, IdempotencyKeyTTL.Seconds(), IdempotencyKeyLease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	query =
+		`
+		INSERT INTO idempotency_keys(key, fingerprint)
+		VALUES($1, $2)
+		ON CONFLICT (key) DO NOTHING
+		RETURNING key
+	`
+
+	err = m.DB.QueryRowContext(ctx, query, key, fingerprint).Scan(&key)
+	if err == nil {
+		return nil, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	//somebody else holds the key
+	query =
+		`
+		SELECT key, created_at, fingerprint, status, headers, body
+		FROM idempotency_keys
+		WHERE key = $1
+	`
+
+	var stored IdempotencyKey
+	var headers []byte
+
+	err = m.DB.QueryRowContext(ctx, query, key).Scan(
+		&stored.Key,
+		&stored.CreatedAt,
+		&stored.Fingerprint,
+		&stored.Status,
+		&headers,
+		&stored.Body,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(headers, &stored.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+// Complete() stores the response for a key reserved with Reserve()
+func (m IdempotencyModel) Complete(key string, status int, headers map[string][]string, body []byte) error {
+
+	js, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	query :=
+		`
+		UPDATE idempotency_keys
+		SET status = $1, headers = $2, body = $3
+		WHERE key = $4
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, status, js, body, key)
+
+	return err
+}
+
+// Release() frees a reserved key so that the request can be retried
+func (m IdempotencyModel) Release(key string) error {
+
+	query :=
+		`
+		DELETE FROM idempotency_keys WHERE key = $1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+
+	return err
+}
+
+// DeleteExpired() removes every key older than IdempotencyKeyTTL
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+
+	query :=
+		`
+		DELETE FROM idempotency_keys
+		WHERE created_at < NOW() - make_interval(secs => $1)
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, IdempotencyKeyTTL.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,600 @@
+//Filename: internal/data/memory.go
+
+package data
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// the memoryStore holds the todo tasks and their change log for the in-memory
+// models. It mirrors the todo and todo_events tables
+type memoryStore struct {
+	mu     sync.RWMutex
+	todos  map[int64]*Todo
+	nextID int64
+	events []Event
+	// every Listen() call gets its own channel
+	listeners []chan struct{}
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		todos:  make(map[int64]*Todo),
+		nextID: 1,
+	}
+}
+
+// record() appends an event the way the todo_notify trigger does, the caller holds the lock
+func (s *memoryStore) record(op string, todo *Todo) {
+	s.events = append(s.events, Event{
+		ID:           int64(len(s.events) + 1),
+		CreatedAt:    now(),
+		Type:         op,
+		TodoID:       todo.ID,
+		TodoPublicID: todo.PublicID,
+	})
+
+	//a listener that hasn't caught up yet is woken once
+	for _, wake := range s.listeners {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// now() returns the current time at the precision of the TIMESTAMP(0) columns
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// copyTodo() returns a copy so that callers never share a todo with the store
+func copyTodo(todo *Todo) *Todo {
+	c := *todo
+	if todo.CompletedAt != nil {
+		completedAt := *todo.CompletedAt
+		c.CompletedAt = &completedAt
+	}
+	return &c
+}
+
+//Define a MemoryTodoModel which keeps todo tasks in memory
+
+type MemoryTodoModel struct {
+	store *memoryStore
+}
+
+// insert() create todo task
+func (m MemoryTodoModel) Insert(todo *Todo) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	if m.store.hasPublicID(todo.PublicID) {
+		return ErrDuplicatePublicID
+	}
+
+	m.store.insert(todo)
+
+	return nil
+}
+
+// InsertMany() creates todo tasks while holding the lock, so no reader sees
+// only some of them
+func (m MemoryTodoModel) InsertMany(todos []*Todo) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, todo := range todos {
+		key := strings.ToLower(todo.PublicID)
+		if m.store.hasPublicID(todo.PublicID) || (key != "" && seen[key]) {
+			return ErrDuplicatePublicID
+		}
+		seen[key] = true
+	}
+
+	for _, todo := range todos {
+		m.store.insert(todo)
+	}
+
+	return nil
+}
+
+// hasPublicID() reports whether a todo task has the public id, the caller
+// holds the lock
+func (s *memoryStore) hasPublicID(publicID string) bool {
+	_, ok := s.byPublicID(publicID)
+	return ok
+}
+
+// byPublicID() finds the todo task with the public id in any case, the caller
+// holds the lock
+func (s *memoryStore) byPublicID(publicID string) (*Todo, bool) {
+	if publicID == "" {
+		return nil, false
+	}
+
+	for _, todo := range s.todos {
+		if strings.EqualFold(todo.PublicID, publicID) {
+			return todo, true
+		}
+	}
+
+	return nil, false
+}
+
+// insert() assigns the id and timestamps of a new todo and stores a copy, the
+// caller holds the lock
+func (s *memoryStore) insert(todo *Todo) {
+	todo.ID = s.nextID
+	if todo.PublicID == "" {
+		todo.PublicID = newPublicID()
+	}
+	todo.CreatedAt = now()
+	todo.UpdatedAt = todo.CreatedAt
+	todo.Version = 1
+	todo.CompletedAt = nil
+	if todo.Completed {
+		completedAt := todo.CreatedAt
+		todo.CompletedAt = &completedAt
+	}
+
+	s.nextID++
+	s.todos[todo.ID] = copyTodo(todo)
+	s.record(EventCreated, todo)
+}
+
+// Get() allow us to retrieve a specific todo task by id
+func (m MemoryTodoModel) Get(id int64) (*Todo, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	todo, ok := m.store.todos[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyTodo(todo), nil
+}
+
+// GetByPublicID() retrieves the todo task a client created under its own id
+func (m MemoryTodoModel) GetByPublicID(publicID string) (*Todo, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	todo, ok := m.store.byPublicID(publicID)
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyTodo(todo), nil
+}
+
+// Update() allow update todo task by id
+func (m MemoryTodoModel) Update(todo *Todo) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, ok := m.store.todos[todo.ID]
+	if !ok || stored.Version != todo.Version {
+		return ErrEditConflict
+	}
+
+	//updated_at and version only move when something changed and completed_at
+	//follows the completed flag
+	if stored.Title != todo.Title || stored.Description != todo.Description || stored.Completed != todo.Completed {
+		stored.UpdatedAt = now()
+		stored.Version++
+	}
+
+	if stored.Completed != todo.Completed {
+		stored.CompletedAt = nil
+		if todo.Completed {
+			completedAt := now()
+			stored.CompletedAt = &completedAt
+		}
+	}
+
+	stored.Title = todo.Title
+	stored.Description = todo.Description
+	stored.Completed = todo.Completed
+
+	todo.UpdatedAt = stored.UpdatedAt
+	todo.Version = stored.Version
+	todo.CompletedAt = copyTodo(stored).CompletedAt
+
+	m.store.record(EventUpdated, stored)
+
+	return nil
+}
+
+// Delete() remove a todo task by id
+func (m MemoryTodoModel) Delete(id int64) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	todo, ok := m.store.todos[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.todos, id)
+	m.store.record(EventDeleted, todo)
+
+	return nil
+}
+
+// DeleteVersion() removes a todo task only while it still has the version,
+// ErrEditConflict means it was changed or deleted since it was read
+func (m MemoryTodoModel) DeleteVersion(id int64, version int32) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	todo, ok := m.store.todos[id]
+	if !ok || todo.Version != version {
+		return ErrEditConflict
+	}
+
+	delete(m.store.todos, id)
+	m.store.record(EventDeleted, todo)
+
+	return nil
+}
+
+// DeleteByPublicID() removes the todo task clients know by publicID
+func (m MemoryTodoModel) DeleteByPublicID(publicID string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	todo, ok := m.store.byPublicID(publicID)
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.store.todos, todo.ID)
+	m.store.record(EventDeleted, todo)
+
+	return nil
+}
+
+// GetAll() returns a page of todo tasks with the same filtering, sorting and
+// pagination as the PostgreSQL model
+func (m MemoryTodoModel) GetAll(search TodoSearch, filters Filters) ([]*Todo, Metadata, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	terms := searchTerms(search.Title)
+
+	todos := []*Todo{}
+	for _, todo := range m.store.todos {
+		if search.matches(todo, terms) {
+			todos = append(todos, copyTodo(todo))
+		}
+	}
+
+	sortTodos(todos, filters)
+
+	totalRecords := len(todos)
+
+	//apply the LIMIT and OFFSET
+	start := filters.offset()
+	if start > len(todos) {
+		start = len(todos)
+	}
+	end := start + filters.limit()
+	if end > len(todos) {
+		end = len(todos)
+	}
+
+	page := todos[start:end]
+
+	//like the COUNT(*) OVER() query an empty page has no metadata
+	if len(page) == 0 {
+		totalRecords = 0
+	}
+
+	return page, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Export() passes copies of the matching todo tasks to fn, the store is not
+// locked while fn runs
+func (m MemoryTodoModel) Export(ctx context.Context, search TodoSearch, filters Filters, fn func(*Todo) error) error {
+	m.store.mu.RLock()
+
+	terms := searchTerms(search.Title)
+
+	todos := []*Todo{}
+	for _, todo := range m.store.todos {
+		if search.matches(todo, terms) {
+			todos = append(todos, copyTodo(todo))
+		}
+	}
+
+	m.store.mu.RUnlock()
+
+	sortTodos(todos, filters)
+
+	for _, todo := range todos {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := fn(todo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetAfter() returns copies of the next limit todo tasks after id, in order of id
+func (m MemoryTodoModel) GetAfter(id int64, limit int) ([]*Todo, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	todos := []*Todo{}
+	for _, todo := range m.store.todos {
+		if todo.ID > id {
+			todos = append(todos, copyTodo(todo))
+		}
+	}
+
+	sort.Slice(todos, func(i, j int) bool { return todos[i].ID < todos[j].ID })
+
+	if len(todos) > limit {
+		todos = todos[:limit]
+	}
+
+	return todos, nil
+}
+
+// searchTerms() splits text into lower case words like the 'simple' text search configuration
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matches() reports whether a todo meets the search criteria, every term has
+// to appear in the title as plainto_tsquery requires
+func (search TodoSearch) matches(todo *Todo, terms []string) bool {
+	if len(terms) > 0 {
+		words := make(map[string]bool)
+		for _, word := range searchTerms(todo.Title) {
+			words[word] = true
+		}
+
+		for _, term := range terms {
+			if !words[term] {
+				return false
+			}
+		}
+	}
+
+	return search.UpdatedAt.contains(&todo.UpdatedAt) && search.CompletedAt.contains(todo.CompletedAt)
+}
+
+// contains() reports whether t falls in the range, nil never does unless the range is open
+func (t TimeRange) contains(value *time.Time) bool {
+	if t.Since.IsZero() && t.Before.IsZero() {
+		return true
+	}
+
+	if value == nil {
+		return false
+	}
+
+	if !t.Since.IsZero() && value.Before(t.Since) {
+		return false
+	}
+
+	if !t.Before.IsZero() && !value.Before(t.Before) {
+		return false
+	}
+
+	return true
+}
+
+// sortTodos() orders todos by the sort column followed by id, as ORDER BY does
+func sortTodos(todos []*Todo, filters Filters) {
+	column := filters.sortColumn()
+	desc := filters.sortOrder() == "DESC"
+
+	sort.SliceStable(todos, func(i, j int) bool {
+		c := compareTodos(todos[i], todos[j], column)
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return todos[i].ID < todos[j].ID
+	})
+}
+
+// compareTodos() compares a single column of two todos. Like PostgreSQL a
+// missing completed_at sorts after every date
+func compareTodos(a, b *Todo, column string) int {
+	switch column {
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "completed":
+		switch {
+		case a.Completed == b.Completed:
+			return 0
+		case !a.Completed:
+			return -1
+		default:
+			return 1
+		}
+	case "created_at":
+		return compareTimes(a.CreatedAt, b.CreatedAt)
+	case "updated_at":
+		return compareTimes(a.UpdatedAt, b.UpdatedAt)
+	case "completed_at":
+		switch {
+		case a.CompletedAt == nil && b.CompletedAt == nil:
+			return 0
+		case a.CompletedAt == nil:
+			return 1
+		case b.CompletedAt == nil:
+			return -1
+		default:
+			return compareTimes(*a.CompletedAt, *b.CompletedAt)
+		}
+	default:
+		switch {
+		case a.ID < b.ID:
+			return -1
+		case a.ID > b.ID:
+			return 1
+		default:
+			return 0
+		}
+	}
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+//Define a MemoryEventModel which reads the change log of a MemoryTodoModel
+
+type MemoryEventModel struct {
+	store *memoryStore
+}
+
+// Latest() returns the id of the most recent event or zero if there are none
+func (m MemoryEventModel) Latest() (int64, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	return int64(len(m.store.events)), nil
+}
+
+// GetSince() returns up to limit events recorded after the event with the given id
+func (m MemoryEventModel) GetSince(id int64, limit int) ([]*Event, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	events := []*Event{}
+
+	//event ids are their position in the log
+	for i := int(id); i >= 0 && i < len(m.store.events) && len(events) < limit; i++ {
+		event := m.store.events[i]
+
+		if todo, ok := m.store.todos[event.TodoID]; ok && event.Type != EventDeleted {
+			event.Todo = copyTodo(todo)
+		}
+
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+// Listen() returns a new channel the store signals on after every change
+func (m MemoryEventModel) Listen(onError func(error)) (<-chan struct{}, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	wake := make(chan struct{}, 1)
+	m.store.listeners = append(m.store.listeners, wake)
+
+	return wake, nil
+}
+
+//Define a MemoryIdempotencyModel which keeps idempotency keys in memory
+
+type MemoryIdempotencyModel struct {
+	mu   *sync.Mutex
+	keys map[string]*IdempotencyKey
+}
+
+func NewMemoryIdempotencyModel() MemoryIdempotencyModel {
+	return MemoryIdempotencyModel{
+		mu:   &sync.Mutex{},
+		keys: make(map[string]*IdempotencyKey),
+	}
+}
+
+// Reserve() claims the key for a new request or returns the stored record
+func (m MemoryIdempotencyModel) Reserve(key, fingerprint string) (*IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//an expired key or an abandoned reservation is free to be used again
+	stored, ok := m.keys[key]
+	if ok && time.Since(stored.CreatedAt) < IdempotencyKeyTTL &&
+		(stored.Status != 0 || time.Since(stored.CreatedAt) < IdempotencyKeyLease) {
+		c := *stored
+		return &c, nil
+	}
+
+	m.keys[key] = &IdempotencyKey{
+		Key:         key,
+		CreatedAt:   time.Now(),
+		Fingerprint: fingerprint,
+	}
+
+	return nil, nil
+}
+
+// Complete() stores the response for a key reserved with Reserve()
+func (m MemoryIdempotencyModel) Complete(key string, status int, headers map[string][]string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.keys[key]
+	if !ok {
+		return nil
+	}
+
+	stored.Status = status
+	stored.Headers = make(map[string][]string, len(headers))
+	for name, values := range headers {
+		stored.Headers[name] = append([]string(nil), values...)
+	}
+	stored.Body = append([]byte(nil), body...)
+
+	return nil
+}
+
+// Release() frees a reserved key so that the request can be retried
+func (m MemoryIdempotencyModel) Release(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.keys, key)
+
+	return nil
+}
+
+// DeleteExpired() removes every key older than IdempotencyKeyTTL
+func (m MemoryIdempotencyModel) DeleteExpired() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	for key, stored := range m.keys {
+		if time.Since(stored.CreatedAt) >= IdempotencyKeyTTL {
+			delete(m.keys, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
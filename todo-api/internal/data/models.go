@@ -3,6 +3,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -10,17 +11,78 @@ import (
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("Edit Conflict")
+	// ErrDuplicatePublicID is returned by Insert() when another todo task has the public id
+	ErrDuplicatePublicID = errors.New("duplicate public id")
 )
 
+// TodoStore is implemented by every storage backend for todo tasks
+type TodoStore interface {
+	Insert(todo *Todo) error
+	// InsertMany() creates every todo task or, on error, none of them
+	InsertMany(todos []*Todo) error
+	Get(id int64) (*Todo, error)
+	// GetByPublicID() finds a todo task by its ULID or the UUID a client put it
+	// under, in any case
+	GetByPublicID(publicID string) (*Todo, error)
+	Update(todo *Todo) error
+	Delete(id int64) error
+	// DeleteVersion() removes a todo task only while it has the version, like
+	// Update() it returns ErrEditConflict otherwise
+	DeleteVersion(id int64, version int32) error
+	// DeleteByPublicID() removes a todo task by the id clients know it by
+	DeleteByPublicID(publicID string) error
+	GetAll(search TodoSearch, filters Filters) ([]*Todo, Metadata, error)
+	// Export() passes every todo task matching the search to fn in the order of
+	// filters.Sort, without paging. It stops at the first error fn returns
+	Export(ctx context.Context, search TodoSearch, filters Filters, fn func(*Todo) error) error
+	// GetAfter() returns up to limit todo tasks with an id greater than id, in
+	// order of id
+	GetAfter(id int64, limit int) ([]*Todo, error)
+}
+
+// EventStore gives access to the log of changes made to todo tasks
+type EventStore interface {
+	Latest() (int64, error)
+	GetSince(id int64, limit int) ([]*Event, error)
+	// Listen() returns a channel that receives a value whenever new events may
+	// have been recorded, problems are passed to onError
+	Listen(onError func(error)) (<-chan struct{}, error)
+}
+
+// IdempotencyStore keeps the responses to requests sent with an Idempotency-Key
+type IdempotencyStore interface {
+	Reserve(key, fingerprint string) (*IdempotencyKey, error)
+	Complete(key string, status int, headers map[string][]string, body []byte) error
+	Release(key string) error
+	DeleteExpired() (int64, error)
+}
+
 // A wrapper for our data models
 type Models struct {
-	Todos TodoModel
+	Todos       TodoStore
+	Events      EventStore
+	Idempotency IdempotencyStore
 }
 
-// NewModels allow us to create a new models
-func NewModels(db *sql.DB) Models {
+// NewModels allow us to create a new models backed by PostgreSQL, dsn is used
+// for the connection that listens for change notifications
+func NewModels(db *sql.DB, dsn string) Models {
+
+	return Models{
+		Todos:       TodoModel{DB: db},
+		Events:      EventModel{DB: db, DSN: dsn},
+		Idempotency: IdempotencyModel{DB: db},
+	}
+}
+
+// NewMemoryModels create models that keep everything in memory, for tests and demos
+func NewMemoryModels() Models {
+
+	store := newMemoryStore()
 
 	return Models{
-		Todos: TodoModel{DB: db},
+		Todos:       MemoryTodoModel{store},
+		Events:      MemoryEventModel{store},
+		Idempotency: NewMemoryIdempotencyModel(),
 	}
 }
@@ -0,0 +1,709 @@
+//Filename: internal/data/sqlite.go
+
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// NewSQLiteModels create models backed by a SQLite database
+func NewSQLiteModels(db *sql.DB) Models {
+
+	return Models{
+		Todos:       SQLiteTodoModel{DB: db},
+		Events:      SQLiteEventModel{DB: db},
+		Idempotency: SQLiteIdempotencyModel{DB: db},
+	}
+}
+
+// sqliteMatch() turns a title search into an FTS5 query that, like
+// plainto_tsquery, requires every word to be present
+func sqliteMatch(title string) string {
+	terms := searchTerms(title)
+	for i, term := range terms {
+		terms[i] = `"` + term + `"`
+	}
+	return strings.Join(terms, " ")
+}
+
+// sqliteTime() returns a query argument for an optional time. Timestamps are
+// compared as text so they are always stored in UTC
+func sqliteTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+//Define a SQLiteTodoModel which wrap a sql.DB connection pool
+
+type SQLiteTodoModel struct {
+	DB *sql.DB
+}
+
+// the statement used by Insert() and InsertMany()
+const sqliteInsertTodoQuery = `
+		INSERT INTO todo(title, description, completed, created_at, updated_at, completed_at, public_id)
+		VALUES(?1, ?2, ?3, ?4, ?4, CASE WHEN ?3 THEN ?4 END, NULLIF(?5, ''))
+		RETURNING id, created_at, updated_at, completed_at, version
+	`
+
+// insert() create todo task
+func (m SQLiteTodoModel) Insert(todo *Todo) error {
+	if todo.PublicID == "" {
+		todo.PublicID = newPublicID()
+	}
+
+	args := []interface{}{todo.Title, todo.Description, todo.Completed, now(), todo.PublicID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+	//cleanup to prevent memory leak
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, sqliteInsertTodoQuery, args...).Scan(&todo.ID, &todo.CreatedAt, &todo.UpdatedAt, &todo.CompletedAt, &todo.Version)
+	if err != nil {
+		//the unique index on public_id
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return ErrDuplicatePublicID
+		}
+		return err
+	}
+
+	return nil
+}
+
+// InsertMany() creates todo tasks in a single transaction, either all of them
+// are created or none are
+func (m SQLiteTodoModel) InsertMany(todos []*Todo) error {
+	//large imports get more time than a single insert
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	//rollback is a no-op after a successful commit
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, sqliteInsertTodoQuery)
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	for _, todo := range todos {
+		if todo.PublicID == "" {
+			todo.PublicID = newPublicID()
+		}
+
+		err = stmt.QueryRowContext(ctx, todo.Title, todo.Description, todo.Completed, now(), todo.PublicID).Scan(&todo.ID, &todo.CreatedAt, &todo.UpdatedAt, &todo.CompletedAt, &todo.Version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get() allow us to retrieve a specific todo task by id
+func (m SQLiteTodoModel) Get(id int64) (*Todo, error) {
+
+	//Ensure id is valid
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.get("id = ?1", id)
+}
+
+// GetByPublicID() retrieves the todo task a client created under its own id
+func (m SQLiteTodoModel) GetByPublicID(publicID string) (*Todo, error) {
+	if publicID == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.get("lower(public_id) = lower(?1)", publicID)
+}
+
+// get() retrieves the todo task matching the condition
+func (m SQLiteTodoModel) get(condition string, arg interface{}) (*Todo, error) {
+	query :=
+		`
+		SELECT id, created_at, title, description, completed, updated_at, completed_at, COALESCE(public_id, ''), version FROM todo
+		WHERE ` + condition
+
+	var todo Todo
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(
+		&todo.ID,
+		&todo.CreatedAt,
+		&todo.Title,
+		&todo.Description,
+		&todo.Completed,
+		&todo.UpdatedAt,
+		&todo.CompletedAt,
+		&todo.PublicID,
+		&todo.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &todo, nil
+}
+
+// Update() allow update todo task by id, with the same timestamp rules as TodoModel
+func (m SQLiteTodoModel) Update(todo *Todo) error {
+
+	query :=
+		`
+		UPDATE todo
+		SET title = ?1, description = ?2, completed = ?3,
+			updated_at = CASE
+				WHEN title IS NOT ?1 OR description IS NOT ?2 OR completed IS NOT ?3 THEN ?5
+				ELSE updated_at
+			END,
+			completed_at = CASE
+				WHEN completed IS NOT ?3 THEN CASE WHEN ?3 THEN ?5 END
+				ELSE completed_at
+			END,
+			version = CASE
+				WHEN title IS NOT ?1 OR description IS NOT ?2 OR completed IS NOT ?3 THEN version + 1
+				ELSE version
+			END
+		WHERE id = ?4 AND version = ?6
+		RETURNING updated_at, completed_at, version
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		todo.Title,
+		todo.Description,
+		todo.Completed,
+		todo.ID,
+		now(),
+		todo.Version,
+	}
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&todo.UpdatedAt, &todo.CompletedAt, &todo.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete() remove a todo task by id
+func (m SQLiteTodoModel) Delete(id int64) error {
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	return m.delete("id = ?1", id)
+}
+
+// DeleteVersion() removes a todo task only while it still has the version,
+// ErrEditConflict means it was changed or deleted since it was read
+func (m SQLiteTodoModel) DeleteVersion(id int64, version int32) error {
+	err := m.delete("id = ?1 AND version = ?2", id, version)
+	if errors.Is(err, ErrRecordNotFound) {
+		return ErrEditConflict
+	}
+
+	return err
+}
+
+// DeleteByPublicID() removes the todo task clients know by publicID
+func (m SQLiteTodoModel) DeleteByPublicID(publicID string) error {
+	if publicID == "" {
+		return ErrRecordNotFound
+	}
+
+	return m.delete("lower(public_id) = lower(?1)", publicID)
+}
+
+// delete() removes the todo task matching the condition
+func (m SQLiteTodoModel) delete(condition string, args ...interface{}) error {
+	query :=
+		`
+		DELETE FROM todo WHERE ` + condition
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAll() returns a page of todo tasks, titles are searched through the todo_fts index
+func (m SQLiteTodoModel) GetAll(search TodoSearch, filters Filters) ([]*Todo, Metadata, error) {
+
+	//an empty MATCH is a syntax error, so the index is only consulted when
+	//searching. A title without any words matches nothing, as in PostgreSQL
+	titleClause := "1"
+	if search.Title != "" {
+		titleClause = "0"
+		if sqliteMatch(search.Title) != "" {
+			titleClause = "id IN (SELECT rowid FROM todo_fts WHERE todo_fts MATCH ?1)"
+		}
+	}
+
+	//SQLite sorts NULLs first, PostgreSQL sorts them last
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, created_at, title, description, completed, updated_at, completed_at, COALESCE(public_id, ''), version
+		FROM todo
+		WHERE %s
+		AND (updated_at >= ?4 OR ?4 IS NULL)
+		AND (updated_at < ?5 OR ?5 IS NULL)
+		AND (completed_at >= ?6 OR ?6 IS NULL)
+		AND (completed_at < ?7 OR ?7 IS NULL)
+		ORDER BY %s %s NULLS %s, id ASC LIMIT ?2 OFFSET ?3`, titleClause, filters.sortColumn(), filters.sortOrder(), nullsOrder(filters))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{
+		sqliteMatch(search.Title),
+		filters.limit(),
+		filters.offset(),
+		sqliteTime(search.UpdatedAt.Since),
+		sqliteTime(search.UpdatedAt.Before),
+		sqliteTime(search.CompletedAt.Since),
+		sqliteTime(search.CompletedAt.Before),
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	todos := []*Todo{}
+
+	for rows.Next() {
+		var todo Todo
+
+		err := rows.Scan(
+			&totalRecords,
+			&todo.ID,
+			&todo.CreatedAt,
+			&todo.Title,
+			&todo.Description,
+			&todo.Completed,
+			&todo.UpdatedAt,
+			&todo.CompletedAt,
+			&todo.PublicID,
+			&todo.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		todos = append(todos, &todo)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return todos, metadata, nil
+}
+
+// Export() streams every todo task matching the search to fn straight from the result set
+func (m SQLiteTodoModel) Export(ctx context.Context, search TodoSearch, filters Filters, fn func(*Todo) error) error {
+	titleClause := "1"
+	if search.Title != "" {
+		titleClause = "0"
+		if sqliteMatch(search.Title) != "" {
+			titleClause = "id IN (SELECT rowid FROM todo_fts WHERE todo_fts MATCH ?1)"
+		}
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, created_at, title, description, completed, updated_at, completed_at, COALESCE(public_id, ''), version
+		FROM todo
+		WHERE %s
+		AND (updated_at >= ?2 OR ?2 IS NULL)
+		AND (updated_at < ?3 OR ?3 IS NULL)
+		AND (completed_at >= ?4 OR ?4 IS NULL)
+		AND (completed_at < ?5 OR ?5 IS NULL)
+		ORDER BY %s %s NULLS %s, id ASC`, titleClause, filters.sortColumn(), filters.sortOrder(), nullsOrder(filters))
+
+	args := []interface{}{
+		sqliteMatch(search.Title),
+		sqliteTime(search.UpdatedAt.Since),
+		sqliteTime(search.UpdatedAt.Before),
+		sqliteTime(search.CompletedAt.Since),
+		sqliteTime(search.CompletedAt.Before),
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	return scanTodos(rows, fn)
+}
+
+// GetAfter() returns the next limit todo tasks after id, in order of id
+func (m SQLiteTodoModel) GetAfter(id int64, limit int) ([]*Todo, error) {
+	query := `
+		SELECT id, created_at, title, description, completed, updated_at, completed_at, COALESCE(public_id, ''), version
+		FROM todo
+		WHERE id > ?
+		ORDER BY id ASC
+		LIMIT ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	todos := []*Todo{}
+	err = scanTodos(rows, func(todo *Todo) error {
+		todos = append(todos, todo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// nullsOrder() matches PostgreSQL's default of NULLS LAST for ascending order
+func nullsOrder(filters Filters) string {
+	if filters.sortOrder() == "DESC" {
+		return "FIRST"
+	}
+	return "LAST"
+}
+
+//Define a SQLiteEventModel which reads the todo_events table
+
+type SQLiteEventModel struct {
+	DB *sql.DB
+}
+
+// Latest() returns the id of the most recent event or zero if there are none
+func (m SQLiteEventModel) Latest() (int64, error) {
+
+	query :=
+		`
+		SELECT COALESCE(MAX(id), 0) FROM todo_events
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+	err := m.DB.QueryRowContext(ctx, query).Scan(&id)
+
+	return id, err
+}
+
+// GetSince() returns up to limit events recorded after the event with the given id.
+// SQLite has a single writer, so event ids are committed in the order they are handed out
+func (m SQLiteEventModel) GetSince(id int64, limit int) ([]*Event, error) {
+
+	query :=
+		`
+		SELECT e.id, e.created_at, e.op, e.todo_id, COALESCE(e.todo_public_id, ''),
+			t.id, t.created_at, t.title, t.description, t.completed, t.updated_at, t.completed_at, t.public_id, t.version
+		FROM todo_events e
+		LEFT JOIN todo t ON t.id = e.todo_id AND e.op <> 'deleted'
+		WHERE e.id > ?1
+		ORDER BY e.id ASC LIMIT ?2
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := []*Event{}
+
+	for rows.Next() {
+		var event Event
+
+		//the todo columns are null for deleted tasks
+		var (
+			todoID      sql.NullInt64
+			createdAt   sql.NullTime
+			title       sql.NullString
+			description sql.NullString
+			completed   sql.NullBool
+			updatedAt   sql.NullTime
+			completedAt sql.NullTime
+			publicID    sql.NullString
+			version     sql.NullInt32
+		)
+
+		err := rows.Scan(
+			&event.ID,
+			&event.CreatedAt,
+			&event.Type,
+			&event.TodoID,
+			&event.TodoPublicID,
+			&todoID,
+			&createdAt,
+			&title,
+			&description,
+			&completed,
+			&updatedAt,
+			&completedAt,
+			&publicID,
+			&version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if todoID.Valid {
+			event.Todo = &Todo{
+				ID:          todoID.Int64,
+				CreatedAt:   createdAt.Time,
+				Title:       title.String,
+				Description: description.String,
+				Completed:   completed.Bool,
+				UpdatedAt:   updatedAt.Time,
+				PublicID:    publicID.String,
+				Version:     version.Int32,
+			}
+
+			if completedAt.Valid {
+				event.Todo.CompletedAt = &completedAt.Time
+			}
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Listen() polls the todo_events table once a second since SQLite has no
+// notifications. Polling also picks up changes made by other processes
+func (m SQLiteEventModel) Listen(onError func(error)) (<-chan struct{}, error) {
+
+	lastID, err := m.Latest()
+	if err != nil {
+		return nil, err
+	}
+
+	wake := make(chan struct{}, 1)
+
+	go func() {
+		for range time.Tick(time.Second) {
+			latest, err := m.Latest()
+			if err != nil {
+				onError(err)
+				continue
+			}
+
+			if latest == lastID {
+				continue
+			}
+			lastID = latest
+
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return wake, nil
+}
+
+//Define a SQLiteIdempotencyModel which wrap a sql.DB connection pool
+
+type SQLiteIdempotencyModel struct {
+	DB *sql.DB
+}
+
+// Reserve() claims the key for a new request or returns the stored record
+func (m SQLiteIdempotencyModel) Reserve(key, fingerprint string) (*IdempotencyKey, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	//an expired key or an abandoned reservation is free to be used again
+	query :=
+		`
+		DELETE FROM idempotency_keys
+		WHERE key = ?1 AND (created_at < ?2 OR (status = 0 AND created_at < ?3))
+	`
+
+	now := time.Now().UTC()
+	_, err := m.DB.ExecContext(ctx, query, key, now.Add(-IdempotencyKeyTTL), now.Add(-IdempotencyKeyLease))
+	if err != nil {
+		return nil, err
+	}
+
+	query =
+		`
+		INSERT INTO idempotency_keys(key, fingerprint, created_at)
+		VALUES(?1, ?2, ?3)
+		ON CONFLICT (key) DO NOTHING
+		RETURNING key
+	`
+
+	err = m.DB.QueryRowContext(ctx, query, key, fingerprint, now).Scan(&key)
+	if err == nil {
+		return nil, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	query =
+		`
+		SELECT key, created_at, fingerprint, status, headers, body
+		FROM idempotency_keys
+		WHERE key = ?1
+	`
+
+	var stored IdempotencyKey
+	var headers string
+
+	err = m.DB.QueryRowContext(ctx, query, key).Scan(
+		&stored.Key,
+		&stored.CreatedAt,
+		&stored.Fingerprint,
+		&stored.Status,
+		&headers,
+		&stored.Body,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(headers), &stored.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stored, nil
+}
+
+// Complete() stores the response for a key reserved with Reserve()
+func (m SQLiteIdempotencyModel) Complete(key string, status int, headers map[string][]string, body []byte) error {
+
+	js, err := json.Marshal(headers)
+	if err != nil {
+		return err
+	}
+
+	query :=
+		`
+		UPDATE idempotency_keys
+		SET status = ?1, headers = ?2, body = ?3
+		WHERE key = ?4
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, status, string(js), body, key)
+
+	return err
+}
+
+// Release() frees a reserved key so that the request can be retried
+func (m SQLiteIdempotencyModel) Release(key string) error {
+
+	query :=
+		`
+		DELETE FROM idempotency_keys WHERE key = ?1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key)
+
+	return err
+}
+
+// DeleteExpired() removes every key older than IdempotencyKeyTTL
+func (m SQLiteIdempotencyModel) DeleteExpired() (int64, error) {
+
+	query :=
+		`
+		DELETE FROM idempotency_keys WHERE created_at < ?1
+	`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now().UTC().Add(-IdempotencyKeyTTL))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
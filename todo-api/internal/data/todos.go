@@ -7,17 +7,34 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
+	"github.com/oklog/ulid/v2"
 	"todo.imerlopez.net/internal/validator"
 )
 
+// Todo is a todo task. ID is the row number used inside the API, clients only
+// see PublicID, a ULID or the UUID a client put the task under
 type Todo struct {
-	ID          int64     `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Completed   bool      `json:"completed"`
+	ID          int64      `json:"-"`
+	PublicID    string     `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Completed   bool       `json:"completed"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	Version     int32      `json:"version"`
+}
+
+// TodoSearch holds the criteria used to select todo tasks in GetAll
+type TodoSearch struct {
+	Title       string
+	UpdatedAt   TimeRange
+	CompletedAt TimeRange
 }
 
 func ValidateTodo(v *validator.Validator, todo *Todo) {
@@ -39,25 +56,100 @@ type TodoModel struct {
 	DB *sql.DB
 }
 
+// newPublicID() returns a ULID for a todo task created without a client id,
+// ULIDs sort by creation time without giving away how many tasks there are
+func newPublicID() string {
+	return ulid.Make().String()
+}
+
+// client supplied ids are UUIDs in their canonical text form
+var uuidRX = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ParsePublicID() reports whether s is a ULID or a UUID and returns it in its
+// canonical case, upper case ULIDs and lower case UUIDs. Stored ids keep the
+// case they were created with, lookups ignore it
+func ParsePublicID(s string) (string, bool) {
+	if uuidRX.MatchString(s) {
+		return strings.ToLower(s), true
+	}
+
+	id, err := ulid.ParseStrict(s)
+	if err != nil {
+		return "", false
+	}
+
+	return id.String(), true
+}
+
+// the statement used by Insert() and InsertMany()
+const insertTodoQuery = `
+		INSERT INTO todo(title, description, completed, completed_at, public_id)
+		values($1,$2,$3, CASE WHEN $3 THEN NOW() END, NULLIF($4, ''))
+		RETURNING id, created_at, updated_at, completed_at, version
+	`
+
 // insert() create todo task
 func (m TodoModel) Insert(todo *Todo) error {
 
-	//insert query to add data to todo table
+	if todo.PublicID == "" {
+		todo.PublicID = newPublicID()
+	}
 
-	query :=
-		`	
-		INSERT INTO todo(title, description, completed) 
-		values($1,$2,$3)
-		RETURNING id, created_at
-	`
-	args := []interface{}{todo.Title, todo.Description, todo.Completed}
+	//insert query to add data to todo table
+	args := []interface{}{todo.Title, todo.Description, todo.Completed, todo.PublicID}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	//cleanup to prevent memory leak
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&todo.ID, &todo.CreatedAt)
+	err := m.DB.QueryRowContext(ctx, insertTodoQuery, args...).Scan(&todo.ID, &todo.CreatedAt, &todo.UpdatedAt, &todo.CompletedAt, &todo.Version)
+	if err != nil {
+		//the unique index on public_id
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicatePublicID
+		}
+		return err
+	}
+
+	return nil
+}
+
+// InsertMany() creates todo tasks in a single transaction, either all of them
+// are created or none are
+func (m TodoModel) InsertMany(todos []*Todo) error {
+	//large imports get more time than a single insert
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	//rollback is a no-op after a successful commit
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertTodoQuery)
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	for _, todo := range todos {
+		if todo.PublicID == "" {
+			todo.PublicID = newPublicID()
+		}
+
+		err = stmt.QueryRowContext(ctx, todo.Title, todo.Description, todo.Completed, todo.PublicID).Scan(&todo.ID, &todo.CreatedAt, &todo.UpdatedAt, &todo.CompletedAt, &todo.Version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // Get() allow us to retrieve a specific todo task by id
@@ -68,13 +160,26 @@ func (m TodoModel) Get(id int64) (*Todo, error) {
 		return nil, ErrRecordNotFound
 	}
 
-	//query to get todo task by id
+	return m.get("id = $1", id)
+}
+
+// GetByPublicID() retrieves the todo task a client created under its own id
+func (m TodoModel) GetByPublicID(publicID string) (*Todo, error) {
+	if publicID == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.get("lower(public_id) = lower($1)", publicID)
+}
+
+// get() retrieves the todo task matching the condition
+func (m TodoModel) get(condition string, arg interface{}) (*Todo, error) {
+
+	//query to get todo task
 	query :=
 		`
-		SELECT id, created_at, title, description, completed FROM todo
-		WHERE id = $1
-
-	`
+		SELECT id, created_at, title, description, completed, updated_at, completed_at, COALESCE(public_id, ''), version FROM todo
+		WHERE ` + condition
 
 	//Declare Todo variable to hold return results
 
@@ -88,12 +193,16 @@ func (m TodoModel) Get(id int64) (*Todo, error) {
 
 	//Execute the query using QueryRow()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(
 		&todo.ID,
 		&todo.CreatedAt,
 		&todo.Title,
 		&todo.Description,
 		&todo.Completed,
+		&todo.UpdatedAt,
+		&todo.CompletedAt,
+		&todo.PublicID,
+		&todo.Version,
 	)
 	if err != nil {
 		//check type of err
@@ -112,14 +221,28 @@ func (m TodoModel) Get(id int64) (*Todo, error) {
 // Update() allow update todo task by id
 func (m TodoModel) Update(todo *Todo) error {
 
-	//query to update todo task record
+	//query to update todo task record, updated_at and version only move when
+	//something changed and completed_at follows the completed flag. A todo
+	//task changed since it was read is an edit conflict
 
 	query :=
 		`
 		UPDATE todo 
-		SET title = $1, description = $2, completed = $3
-		WHERE id = $4
-		RETURNING id
+		SET title = $1, description = $2, completed = $3,
+			updated_at = CASE
+				WHEN (title, description, completed) IS DISTINCT FROM ($1::text, $2::text, $3::boolean) THEN NOW()
+				ELSE updated_at
+			END,
+			completed_at = CASE
+				WHEN completed IS DISTINCT FROM $3::boolean THEN CASE WHEN $3::boolean THEN NOW() END
+				ELSE completed_at
+			END,
+			version = CASE
+				WHEN (title, description, completed) IS DISTINCT FROM ($1::text, $2::text, $3::boolean) THEN version + 1
+				ELSE version
+			END
+		WHERE id = $4 AND version = $5
+		RETURNING updated_at, completed_at, version
 		
 	`
 	//create context
@@ -133,10 +256,11 @@ func (m TodoModel) Update(todo *Todo) error {
 		todo.Description,
 		todo.Completed,
 		todo.ID,
+		todo.Version,
 	}
 
 	//check for edit conflicts
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&todo.ID)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&todo.UpdatedAt, &todo.CompletedAt, &todo.Version)
 
 	if err != nil {
 		switch {
@@ -159,18 +283,44 @@ func (m TodoModel) Delete(id int64) error {
 		return ErrRecordNotFound
 	}
 
+	return m.delete("id = $1", id)
+}
+
+// DeleteVersion() removes a todo task only while it still has the version,
+// ErrEditConflict means it was changed or deleted since it was read
+func (m TodoModel) DeleteVersion(id int64, version int32) error {
+	err := m.delete("id = $1 AND version = $2", id, version)
+	if errors.Is(err, ErrRecordNotFound) {
+		return ErrEditConflict
+	}
+
+	return err
+}
+
+// DeleteByPublicID() removes the todo task clients know by publicID
+func (m TodoModel) DeleteByPublicID(publicID string) error {
+	if publicID == "" {
+		return ErrRecordNotFound
+	}
+
+	return m.delete("lower(public_id) = lower($1)", publicID)
+}
+
+// delete() removes the todo task matching the condition
+func (m TodoModel) delete(condition string, args ...interface{}) error {
+
 	//Delete query
 	query :=
 		`
-		DELETE FROM todo WHERE id = $1
-	`
+		DELETE FROM todo WHERE ` + condition
+
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 
 	//cleanup to prevent memory leak
 	defer cancel()
 
 	//Execute Delete query
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, args...)
 
 	//Check for error
 	if err != nil {
@@ -198,19 +348,30 @@ func (m TodoModel) Delete(id int64) error {
 
 //get all method returns a list of all schools sort by id
 
-func (m TodoModel) GetAll(title string, filters Filters) ([]*Todo, Metadata, error) {
+func (m TodoModel) GetAll(search TodoSearch, filters Filters) ([]*Todo, Metadata, error) {
 	//construct query
 
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, created_at, title, description, completed
+		SELECT COUNT(*) OVER(), id, created_at, title, description, completed, updated_at, completed_at, COALESCE(public_id, ''), version
 		FROM todo
 		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-		
+		AND (updated_at >= $4 OR $4 IS NULL)
+		AND (updated_at < $5 OR $5 IS NULL)
+		AND (completed_at >= $6 OR $6 IS NULL)
+		AND (completed_at < $7 OR $7 IS NULL)
 		ORDER BY %s %s, id ASC LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortOrder())
 	//CREATE a 3 sec timeout context
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	args := []interface{}{title, filters.limit(), filters.offset()}
+	args := []interface{}{
+		search.Title,
+		filters.limit(),
+		filters.offset(),
+		search.UpdatedAt.since(),
+		search.UpdatedAt.before(),
+		search.CompletedAt.since(),
+		search.CompletedAt.before(),
+	}
 	//execute
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 
@@ -239,6 +400,10 @@ func (m TodoModel) GetAll(title string, filters Filters) ([]*Todo, Metadata, err
 			&todo.Title,
 			&todo.Description,
 			&todo.Completed,
+			&todo.UpdatedAt,
+			&todo.CompletedAt,
+			&todo.PublicID,
+			&todo.Version,
 		)
 
 		if err != nil {
@@ -261,3 +426,94 @@ func (m TodoModel) GetAll(title string, filters Filters) ([]*Todo, Metadata, err
 	return todos, metadata, nil
 
 }
+
+// Export() streams every todo task matching the search to fn straight from the
+// result set, ctx bounds the whole export instead of the usual 3 second timeout
+func (m TodoModel) Export(ctx context.Context, search TodoSearch, filters Filters, fn func(*Todo) error) error {
+	query := fmt.Sprintf(`
+		SELECT id, created_at, title, description, completed, updated_at, completed_at, COALESCE(public_id, ''), version
+		FROM todo
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (updated_at >= $2 OR $2 IS NULL)
+		AND (updated_at < $3 OR $3 IS NULL)
+		AND (completed_at >= $4 OR $4 IS NULL)
+		AND (completed_at < $5 OR $5 IS NULL)
+		ORDER BY %s %s, id ASC`, filters.sortColumn(), filters.sortOrder())
+
+	args := []interface{}{
+		search.Title,
+		search.UpdatedAt.since(),
+		search.UpdatedAt.before(),
+		search.CompletedAt.since(),
+		search.CompletedAt.before(),
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	return scanTodos(rows, fn)
+}
+
+// GetAfter() returns the next limit todo tasks after id, in order of id
+func (m TodoModel) GetAfter(id int64, limit int) ([]*Todo, error) {
+	query := `
+		SELECT id, created_at, title, description, completed, updated_at, completed_at, COALESCE(public_id, ''), version
+		FROM todo
+		WHERE id > $1
+		ORDER BY id ASC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	todos := []*Todo{}
+	err = scanTodos(rows, func(todo *Todo) error {
+		todos = append(todos, todo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// scanTodos() passes each row of a todo query to fn as it is read
+func scanTodos(rows *sql.Rows, fn func(*Todo) error) error {
+	for rows.Next() {
+		var todo Todo
+
+		err := rows.Scan(
+			&todo.ID,
+			&todo.CreatedAt,
+			&todo.Title,
+			&todo.Description,
+			&todo.Completed,
+			&todo.UpdatedAt,
+			&todo.CompletedAt,
+			&todo.PublicID,
+			&todo.Version,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&todo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
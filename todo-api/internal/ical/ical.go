@@ -0,0 +1,357 @@
+//Filename: internal/ical/ical.go
+
+// Package ical reads and writes the iCalendar format of RFC 5545
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrInvalid = errors.New("ical: invalid iCalendar data")
+
+// the UTC date-time format, e.g. 20221023T143000Z
+const timeFormat = "20060102T150405Z"
+
+// Component is a BEGIN:NAME ... END:NAME block such as VCALENDAR or VTODO
+type Component struct {
+	Name       string
+	Props      []Property
+	Components []*Component
+}
+
+// Property is a content line, NAME;PARAM=VALUE:value
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// NewComponent() returns an empty component
+func NewComponent(name string) *Component {
+	return &Component{Name: name}
+}
+
+// Add() appends a property with a raw value
+func (c *Component) Add(name, value string) {
+	c.Props = append(c.Props, Property{Name: name, Value: value})
+}
+
+// AddText() appends a property with a TEXT value, escaping it
+func (c *Component) AddText(name, value string) {
+	c.Add(name, EscapeText(value))
+}
+
+// AddTime() appends a property with a UTC DATE-TIME value
+func (c *Component) AddTime(name string, t time.Time) {
+	c.Add(name, t.UTC().Format(timeFormat))
+}
+
+// Get() returns the first property with the name or nil
+func (c *Component) Get(name string) *Property {
+	for i := range c.Props {
+		if strings.EqualFold(c.Props[i].Name, name) {
+			return &c.Props[i]
+		}
+	}
+	return nil
+}
+
+// Text() returns the unescaped TEXT value of a property, or "" when it is missing
+func (c *Component) Text(name string) string {
+	prop := c.Get(name)
+	if prop == nil {
+		return ""
+	}
+	return UnescapeText(prop.Value)
+}
+
+// Time() parses a DATE-TIME or DATE property. Floating times and times with a
+// TZID that isn't known here are read as UTC
+func (c *Component) Time(name string) (time.Time, bool) {
+	prop := c.Get(name)
+	if prop == nil {
+		return time.Time{}, false
+	}
+
+	loc := time.UTC
+	if tzid := prop.Params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	for _, layout := range []string{timeFormat, "20060102T150405", "20060102"} {
+		t, err := time.ParseInLocation(layout, prop.Value, loc)
+		if err == nil {
+			return t.UTC(), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Find() returns the child components with the name
+func (c *Component) Find(name string) []*Component {
+	found := []*Component{}
+	for _, child := range c.Components {
+		if strings.EqualFold(child.Name, name) {
+			found = append(found, child)
+		}
+	}
+	return found
+}
+
+// EscapeText() escapes backslashes, semicolons, commas and newlines of a TEXT value
+func EscapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// UnescapeText() reverses EscapeText()
+func UnescapeText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// Encoder writes components with CRLF line endings, folding lines longer than 75 octets
+type Encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w)}
+}
+
+// Begin() writes the BEGIN line of a component whose children are written separately
+func (e *Encoder) Begin(name string) error {
+	e.line("BEGIN:" + name)
+	return e.Flush()
+}
+
+// End() writes the END line of a component started with Begin()
+func (e *Encoder) End(name string) error {
+	e.line("END:" + name)
+	return e.Flush()
+}
+
+// Encode() writes a whole component
+func (e *Encoder) Encode(c *Component) error {
+	e.component(c)
+	return e.Flush()
+}
+
+// Flush() writes any buffered data
+func (e *Encoder) Flush() error {
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	return e.err
+}
+
+// Property() writes a single property, for components written with Begin() and End()
+func (e *Encoder) Property(prop Property) error {
+	e.property(prop)
+	return e.Flush()
+}
+
+func (e *Encoder) property(prop Property) {
+	var b strings.Builder
+	b.WriteString(prop.Name)
+	names := make([]string, 0, len(prop.Params))
+	for name := range prop.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := prop.Params[name]
+		if strings.ContainsAny(value, ";:,") {
+			value = `"` + value + `"`
+		}
+		fmt.Fprintf(&b, ";%s=%s", name, value)
+	}
+	b.WriteString(":")
+	b.WriteString(prop.Value)
+	e.line(b.String())
+}
+
+func (e *Encoder) component(c *Component) {
+	e.line("BEGIN:" + c.Name)
+
+	for _, prop := range c.Props {
+		e.property(prop)
+	}
+
+	for _, child := range c.Components {
+		e.component(child)
+	}
+
+	e.line("END:" + c.Name)
+}
+
+// line() writes a content line, folded so that no line is longer than 75
+// octets and no UTF-8 sequence is split
+func (e *Encoder) line(s string) {
+	if e.err != nil {
+		return
+	}
+
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+
+		_, e.err = e.w.WriteString(s[:cut] + "\r\n ")
+		if e.err != nil {
+			return
+		}
+
+		s = s[cut:]
+		//continuation lines start with a space
+		limit = 74
+	}
+
+	_, e.err = e.w.WriteString(s + "\r\n")
+}
+
+// Parse() reads a single top level component, usually a VCALENDAR
+func Parse(r io.Reader) (*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var stack []*Component
+	var root *Component
+
+	for n, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		prop, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalid, n+1, err)
+		}
+
+		switch strings.ToUpper(prop.Name) {
+		case "BEGIN":
+			if root != nil && len(stack) == 0 {
+				return nil, fmt.Errorf("%w: line %d: more than one top level component", ErrInvalid, n+1)
+			}
+
+			c := NewComponent(strings.ToUpper(prop.Value))
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, c)
+			} else {
+				root = c
+			}
+			stack = append(stack, c)
+
+		case "END":
+			if len(stack) == 0 || !strings.EqualFold(stack[len(stack)-1].Name, prop.Value) {
+				return nil, fmt.Errorf("%w: line %d: unexpected END:%s", ErrInvalid, n+1, prop.Value)
+			}
+			stack = stack[:len(stack)-1]
+
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%w: line %d: property outside of a component", ErrInvalid, n+1)
+			}
+			c := stack[len(stack)-1]
+			c.Props = append(c.Props, prop)
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("%w: no component", ErrInvalid)
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("%w: %s is not ended", ErrInvalid, stack[len(stack)-1].Name)
+	}
+
+	return root, nil
+}
+
+// unfold() splits the input into content lines, joining folded lines
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(lines) == 0 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseLine() splits a content line into its name, parameters and value
+func parseLine(line string) (Property, error) {
+	prop := Property{Params: map[string]string{}}
+
+	//the value starts at the first colon outside of a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+
+	if colon < 0 {
+		return prop, errors.New("missing ':'")
+	}
+
+	prop.Value = line[colon+1:]
+
+	parts := strings.Split(line[:colon], ";")
+	prop.Name = strings.ToUpper(parts[0])
+	if prop.Name == "" {
+		return prop, errors.New("missing property name")
+	}
+
+	for _, param := range parts[1:] {
+		name, value, _ := strings.Cut(param, "=")
+		prop.Params[strings.ToUpper(name)] = strings.Trim(value, `"`)
+	}
+
+	return prop, nil
+}
@@ -0,0 +1,429 @@
+//Filename: internal/migrate/migrate.go
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// key for the PostgreSQL advisory lock held while migrating
+const lockKey = 2018_10_23
+
+var (
+	ErrNoChange       = errors.New("no change")
+	ErrUnknownVersion = errors.New("unknown migration version")
+	ErrDirty          = errors.New("dirty schema")
+)
+
+// migration files are named 000001_name.up.sql and 000001_name.down.sql
+var fileRX = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a single numbered schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes a migration and when it was applied, AppliedAt is nil for pending migrations
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrator applies migrations to a database and records them in migration_history.
+// The schema_migrations table belongs to golang-migrate, which managed the
+// schema before, its version is adopted the first time the Migrator runs
+type Migrator struct {
+	DB         *sql.DB
+	Driver     string // postgres, sqlite
+	Migrations []Migration
+}
+
+// Load() reads the migrations in dir of fsys, ordered by version
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		matches := fileRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || matches == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		contents, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up() applies every pending migration
+func (m Migrator) Up() error {
+	if len(m.Migrations) == 0 {
+		return ErrNoChange
+	}
+
+	return m.Goto(m.Migrations[len(m.Migrations)-1].Version)
+}
+
+// Down() rolls back the most recently applied migration
+func (m Migrator) Down() error {
+	return m.withLock(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		current := latest(applied)
+		if current == 0 {
+			return ErrNoChange
+		}
+
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			if m.Migrations[i].Version == current {
+				return m.run(conn, m.Migrations[i], false)
+			}
+		}
+
+		return fmt.Errorf("%w: %d is applied but has no migration file", ErrUnknownVersion, current)
+	})
+}
+
+// Goto() migrates up or down until version is the latest applied migration.
+// Version zero rolls back every migration
+func (m Migrator) Goto(version int64) error {
+	if version != 0 && m.find(version) < 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+
+	return m.withLock(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		changed := false
+
+		//roll back newer migrations, newest first
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			migration := m.Migrations[i]
+			if migration.Version > version && applied[migration.Version] != nil {
+				err = m.run(conn, migration, false)
+				if err != nil {
+					return err
+				}
+				changed = true
+			}
+		}
+
+		//then apply anything missing up to the target
+		for _, migration := range m.Migrations {
+			if migration.Version <= version && applied[migration.Version] == nil {
+				err = m.run(conn, migration, true)
+				if err != nil {
+					return err
+				}
+				changed = true
+			}
+		}
+
+		if !changed {
+			return ErrNoChange
+		}
+
+		return nil
+	})
+}
+
+// Status() lists every known migration and whether it has been applied
+func (m Migrator) Status() ([]Status, error) {
+	var statuses []Status
+
+	err := m.withLock(func(conn *sql.Conn) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.Migrations {
+			statuses = append(statuses, Status{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: applied[migration.Version],
+			})
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+// withLock() runs fn on a single connection while holding the migration lock so
+// that instances starting at the same time don't race. SQLite has no such
+// lock, there every migration transaction takes the write lock and run()
+// checks again whether the migration is still needed
+func (m Migrator) withLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if m.Driver == "postgres" {
+		//an advisory lock belongs to the session, so it is taken and
+		//released on the same connection the migrations run on
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockKey)
+		if err != nil {
+			return err
+		}
+
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockKey)
+	}
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS migration_history(
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+
+	err = m.adopt(conn)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// adopt() fills an empty migration_history from the schema_migrations table of
+// golang-migrate, which keeps a single row with the current version and whether
+// it failed half way
+func (m Migrator) adopt(conn *sql.Conn) error {
+	ctx := context.Background()
+
+	var count int
+	err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM migration_history").Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	columns, err := m.columns(conn, "schema_migrations")
+	if err != nil || !columns["dirty"] {
+		return err
+	}
+
+	var version int64
+	var dirty bool
+
+	err = conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if dirty {
+		return fmt.Errorf("%w: golang-migrate left version %d half applied, repair the schema and clear the dirty flag first", ErrDirty, version)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	//rollback is a no-op after a successful commit
+	defer tx.Rollback()
+
+	for _, migration := range m.Migrations {
+		if migration.Version > version {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO migration_history(version, name, applied_at) VALUES($1, $2, $3)",
+			migration.Version, migration.Name, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// columns() returns the names of the columns of table, none when it doesn't exist
+func (m Migrator) columns(conn *sql.Conn, table string) (map[string]bool, error) {
+	query := "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1"
+	if m.Driver == "sqlite" {
+		query = "SELECT name FROM pragma_table_info($1)"
+	}
+
+	rows, err := conn.QueryContext(context.Background(), query, table)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		columns[name] = true
+	}
+
+	return columns, rows.Err()
+}
+
+// applied() returns when each applied migration was applied, keyed by version
+func (m Migrator) applied(conn *sql.Conn) (map[int64]*time.Time, error) {
+	rows, err := conn.QueryContext(context.Background(), "SELECT version, applied_at FROM migration_history")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	applied := make(map[int64]*time.Time)
+
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = &appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// run() applies or rolls back a migration and records it in one transaction
+func (m Migrator) run(conn *sql.Conn, migration Migration, up bool) error {
+	ctx := context.Background()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	//rollback is a no-op after a successful commit
+	defer tx.Rollback()
+
+	//another instance may have got here first
+	var count int
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM migration_history WHERE version = $1", migration.Version).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if (count == 1) == up {
+		return nil
+	}
+
+	script := migration.Down
+	if up {
+		script = migration.Up
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	//both drivers accept $n placeholders
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO migration_history(version, name, applied_at) VALUES($1, $2, $3)",
+			migration.Version, migration.Name, time.Now().UTC())
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM migration_history WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// find() returns the index of the migration with the version or -1
+func (m Migrator) find(version int64) int {
+	for i, migration := range m.Migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// latest() returns the highest applied version or zero
+func latest(applied map[int64]*time.Time) int64 {
+	var current int64
+	for version := range applied {
+		if version > current {
+			current = version
+		}
+	}
+	return current
+}
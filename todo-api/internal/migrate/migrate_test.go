@@ -0,0 +1,95 @@
+//Filename: internal/migrate/migrate_test.go
+
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestMigrator() returns a Migrator with three migrations on an empty SQLite database
+func newTestMigrator(t *testing.T) Migrator {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return Migrator{
+		DB:     db,
+		Driver: "sqlite",
+		Migrations: []Migration{
+			{Version: 1, Name: "one", Up: "CREATE TABLE one(id INTEGER)", Down: "DROP TABLE one"},
+			{Version: 2, Name: "two", Up: "CREATE TABLE two(id INTEGER)", Down: "DROP TABLE two"},
+			{Version: 3, Name: "three", Up: "CREATE TABLE three(id INTEGER)", Down: "DROP TABLE three"},
+		},
+	}
+}
+
+// appliedVersions() returns the versions Status() reports as applied
+func appliedVersions(t *testing.T, m Migrator) []int64 {
+	t.Helper()
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	versions := []int64{}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			versions = append(versions, status.Version)
+		}
+	}
+
+	return versions
+}
+
+func TestUpAdoptsGolangMigrateVersion(t *testing.T) {
+	m := newTestMigrator(t)
+
+	//golang-migrate applied the first two migrations
+	_, err := m.DB.Exec(`
+		CREATE TABLE one(id INTEGER);
+		CREATE TABLE two(id INTEGER);
+		CREATE TABLE schema_migrations(version uint64, dirty bool);
+		INSERT INTO schema_migrations VALUES(2, false);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//running the first two again would fail, their tables exist
+	err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := appliedVersions(t, m); len(got) != 3 {
+		t.Errorf("applied %v, want [1 2 3]", got)
+	}
+}
+
+func TestUpRefusesDirtyGolangMigrateVersion(t *testing.T) {
+	m := newTestMigrator(t)
+
+	_, err := m.DB.Exec(`
+		CREATE TABLE schema_migrations(version uint64, dirty bool);
+		INSERT INTO schema_migrations VALUES(2, true);
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Up()
+	if !errors.Is(err, ErrDirty) {
+		t.Fatalf("got %v, want ErrDirty", err)
+	}
+}
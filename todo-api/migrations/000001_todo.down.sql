@@ -1,3 +1,3 @@
---Filename: migrations/000001_todo.up.sql
+--Filename: migrations/000001_todo.down.sql
 
-DROP TABLE IF EXISTS todo;
\ No newline at end of file
+DROP TABLE IF EXISTS todo;
@@ -1,4 +1,4 @@
---Filename: migrations/000001_todo.down.sql
+--Filename: migrations/000001_todo.up.sql
 
 CREATE TABLE
     IF NOT EXISTS todo(
@@ -9,4 +9,4 @@ CREATE TABLE
             title text NOT NULL,
             description text NOT NULL,
             completed BOOLEAN
-    );
\ No newline at end of file
+    );
@@ -0,0 +1,5 @@
+--Filename: migrations/000002_todo_events.down.sql
+
+DROP TRIGGER IF EXISTS todo_notify ON todo;
+DROP FUNCTION IF EXISTS todo_notify();
+DROP TABLE IF EXISTS todo_events;
@@ -0,0 +1,39 @@
+--Filename: migrations/000002_todo_events.up.sql
+
+CREATE TABLE
+    IF NOT EXISTS todo_events(
+        id bigserial PRIMARY KEY,
+        created_at TIMESTAMP(0)
+        with
+            TIME Zone NOT null DEFAULT Now(),
+            op text NOT NULL,
+            todo_id bigint NOT NULL
+    );
+
+-- every change to the todo table is recorded as an event and announced
+-- on the todo_events channel so that every API instance can pick it up
+CREATE OR REPLACE FUNCTION todo_notify() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO todo_events(op, todo_id) VALUES ('deleted', OLD.id)
+        RETURNING id INTO event_id;
+    ELSIF TG_OP = 'INSERT' THEN
+        INSERT INTO todo_events(op, todo_id) VALUES ('created', NEW.id)
+        RETURNING id INTO event_id;
+    ELSE
+        INSERT INTO todo_events(op, todo_id) VALUES ('updated', NEW.id)
+        RETURNING id INTO event_id;
+    END IF;
+
+    PERFORM pg_notify('todo_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS todo_notify ON todo;
+
+CREATE TRIGGER todo_notify
+    AFTER INSERT OR UPDATE OR DELETE ON todo
+    FOR EACH ROW EXECUTE FUNCTION todo_notify();
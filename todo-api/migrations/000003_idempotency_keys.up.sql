@@ -0,0 +1,17 @@
+--Filename: migrations/000003_idempotency_keys.up.sql
+
+-- responses to requests sent with an Idempotency-Key header, status is zero
+-- while the original request is still being processed
+CREATE TABLE
+    IF NOT EXISTS idempotency_keys(
+        key text PRIMARY KEY,
+        created_at TIMESTAMP(0)
+        with
+            TIME Zone NOT null DEFAULT Now(),
+            fingerprint text NOT NULL,
+            status integer NOT NULL DEFAULT 0,
+            headers jsonb NOT NULL DEFAULT '{}',
+            body bytea
+    );
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys(created_at);
@@ -0,0 +1,3 @@
+--Filename: migrations/000004_todo_updated_at.down.sql
+
+ALTER TABLE todo DROP COLUMN IF EXISTS updated_at;
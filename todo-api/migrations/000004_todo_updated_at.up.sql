@@ -0,0 +1,9 @@
+--Filename: migrations/000004_todo_updated_at.up.sql
+
+ALTER TABLE todo
+ADD
+    COLUMN IF NOT EXISTS updated_at TIMESTAMP(0)
+with
+    TIME Zone NOT null DEFAULT Now();
+
+UPDATE todo SET updated_at = created_at;
@@ -0,0 +1,12 @@
+--Filename: migrations/000005_todo_completed_at.up.sql
+
+ALTER TABLE todo
+ADD
+    COLUMN IF NOT EXISTS completed_at TIMESTAMP(0)
+with
+    TIME Zone;
+
+UPDATE todo SET completed_at = updated_at WHERE completed;
+
+CREATE INDEX IF NOT EXISTS todo_updated_at_idx ON todo(updated_at);
+CREATE INDEX IF NOT EXISTS todo_completed_at_idx ON todo(completed_at);
@@ -0,0 +1,4 @@
+--Filename: migrations/000006_todo_public_id.down.sql
+
+DROP INDEX IF EXISTS todo_public_id_idx;
+ALTER TABLE todo DROP COLUMN IF EXISTS public_id;
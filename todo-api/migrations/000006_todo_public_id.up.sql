@@ -0,0 +1,5 @@
+--Filename: migrations/000006_todo_public_id.up.sql
+
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS public_id text;
+
+CREATE UNIQUE INDEX IF NOT EXISTS todo_public_id_idx ON todo(public_id);
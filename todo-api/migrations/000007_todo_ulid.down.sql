@@ -0,0 +1,24 @@
+--Filename: migrations/000007_todo_ulid.down.sql
+
+-- the generated public ids are kept, they can't be told apart from client ones
+CREATE OR REPLACE FUNCTION todo_notify() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO todo_events(op, todo_id) VALUES ('deleted', OLD.id)
+        RETURNING id INTO event_id;
+    ELSIF TG_OP = 'INSERT' THEN
+        INSERT INTO todo_events(op, todo_id) VALUES ('created', NEW.id)
+        RETURNING id INTO event_id;
+    ELSE
+        INSERT INTO todo_events(op, todo_id) VALUES ('updated', NEW.id)
+        RETURNING id INTO event_id;
+    END IF;
+
+    PERFORM pg_notify('todo_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE todo_events DROP COLUMN IF EXISTS todo_public_id;
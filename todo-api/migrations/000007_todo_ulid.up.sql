@@ -0,0 +1,46 @@
+--Filename: migrations/000007_todo_ulid.up.sql
+
+-- the backfill isn't a change listeners need to hear about
+ALTER TABLE todo DISABLE TRIGGER todo_notify;
+
+-- every todo gets a ULID as its public id, tasks put under a client UUID keep
+-- it. The first 10 characters encode created_at in milliseconds, the other 16
+-- are random
+UPDATE todo SET public_id = (
+    SELECT string_agg(substr('0123456789ABCDEFGHJKMNPQRSTVWXYZ',
+        CASE
+            WHEN i < 10 THEN ((floor(extract(epoch FROM todo.created_at) * 1000)::bigint >> ((9 - i) * 5)) & 31)::int
+            ELSE floor(random() * 32)::int
+        END + 1, 1), '' ORDER BY i)
+    FROM generate_series(0, 25) AS i
+)
+WHERE public_id IS NULL;
+
+ALTER TABLE todo ENABLE TRIGGER todo_notify;
+
+-- events keep the public id, a deleted task can't be joined any more
+ALTER TABLE todo_events ADD COLUMN IF NOT EXISTS todo_public_id text;
+
+UPDATE todo_events e SET todo_public_id = t.public_id
+FROM todo t
+WHERE t.id = e.todo_id;
+
+CREATE OR REPLACE FUNCTION todo_notify() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO todo_events(op, todo_id, todo_public_id) VALUES ('deleted', OLD.id, OLD.public_id)
+        RETURNING id INTO event_id;
+    ELSIF TG_OP = 'INSERT' THEN
+        INSERT INTO todo_events(op, todo_id, todo_public_id) VALUES ('created', NEW.id, NEW.public_id)
+        RETURNING id INTO event_id;
+    ELSE
+        INSERT INTO todo_events(op, todo_id, todo_public_id) VALUES ('updated', NEW.id, NEW.public_id)
+        RETURNING id INTO event_id;
+    END IF;
+
+    PERFORM pg_notify('todo_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,3 @@
+--Filename: migrations/000008_todo_version.down.sql
+
+ALTER TABLE todo DROP COLUMN IF EXISTS version;
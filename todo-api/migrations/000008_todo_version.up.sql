@@ -0,0 +1,5 @@
+--Filename: migrations/000008_todo_version.up.sql
+
+-- the version goes up with every change, updates of an older version are
+-- edit conflicts
+ALTER TABLE todo ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
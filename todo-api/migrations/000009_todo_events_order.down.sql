@@ -0,0 +1,25 @@
+--Filename: migrations/000009_todo_events_order.down.sql
+
+CREATE OR REPLACE FUNCTION todo_notify() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO todo_events(op, todo_id, todo_public_id) VALUES ('deleted', OLD.id, OLD.public_id)
+        RETURNING id INTO event_id;
+    ELSIF TG_OP = 'INSERT' THEN
+        INSERT INTO todo_events(op, todo_id, todo_public_id) VALUES ('created', NEW.id, NEW.public_id)
+        RETURNING id INTO event_id;
+    ELSE
+        INSERT INTO todo_events(op, todo_id, todo_public_id) VALUES ('updated', NEW.id, NEW.public_id)
+        RETURNING id INTO event_id;
+    END IF;
+
+    PERFORM pg_notify('todo_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS todo_events_horizon_idx;
+
+ALTER TABLE todo_events DROP COLUMN IF EXISTS horizon;
@@ -0,0 +1,40 @@
+--Filename: migrations/000009_todo_events_order.up.sql
+
+-- event ids are cursors for streams and sync clients. A sequence hands ids out
+-- in order but transactions commit out of it, a reader could move past an id
+-- that only shows up later. Every event records the next transaction id as of
+-- just after its id was drawn: once no transaction older than that is running,
+-- every lower event id is either committed or gone for good. Readers only move
+-- their cursor up to such settled events. This relies on the read committed
+-- isolation level the API writes with, each statement of the trigger sees a
+-- fresh snapshot
+ALTER TABLE todo_events ADD COLUMN IF NOT EXISTS horizon xid8 NOT NULL DEFAULT '0';
+
+CREATE INDEX IF NOT EXISTS todo_events_horizon_idx ON todo_events(horizon);
+
+CREATE OR REPLACE FUNCTION todo_notify() RETURNS trigger AS $$
+DECLARE
+    event_id bigint;
+    event_op text;
+    event_todo todo;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        event_op := 'deleted';
+        event_todo := OLD;
+    ELSIF TG_OP = 'INSERT' THEN
+        event_op := 'created';
+        event_todo := NEW;
+    ELSE
+        event_op := 'updated';
+        event_todo := NEW;
+    END IF;
+
+    event_id := nextval(pg_get_serial_sequence('todo_events', 'id'));
+
+    INSERT INTO todo_events(id, op, todo_id, todo_public_id, horizon)
+    VALUES (event_id, event_op, event_todo.id, event_todo.public_id, pg_snapshot_xmax(pg_current_snapshot()));
+
+    PERFORM pg_notify('todo_events', event_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,17 @@
+//Filename: migrations/migrations.go
+
+// Package migrations embeds the SQL migrations so that the API binary can
+// apply them itself
+package migrations
+
+import "embed"
+
+// Postgres holds the PostgreSQL migrations
+//
+//go:embed *.sql
+var Postgres embed.FS
+
+// SQLite holds the SQLite migrations, under the sqlite directory
+//
+//go:embed sqlite/*.sql
+var SQLite embed.FS
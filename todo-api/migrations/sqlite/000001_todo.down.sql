@@ -0,0 +1,7 @@
+--Filename: migrations/sqlite/000001_todo.down.sql
+
+DROP TRIGGER IF EXISTS todo_fts_update;
+DROP TRIGGER IF EXISTS todo_fts_delete;
+DROP TRIGGER IF EXISTS todo_fts_insert;
+DROP TABLE IF EXISTS todo_fts;
+DROP TABLE IF EXISTS todo;
@@ -0,0 +1,33 @@
+--Filename: migrations/sqlite/000001_todo.up.sql
+
+CREATE TABLE
+    IF NOT EXISTS todo(
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        title text NOT NULL,
+        description text NOT NULL,
+        completed BOOLEAN
+    );
+
+-- full text index over the titles, kept in sync by the triggers below. The
+-- tokenizer is configured to behave like PostgreSQL's 'simple' configuration
+CREATE VIRTUAL TABLE
+    IF NOT EXISTS todo_fts USING fts5(
+        title,
+        content = 'todo',
+        content_rowid = 'id',
+        tokenize = 'unicode61 remove_diacritics 0'
+    );
+
+CREATE TRIGGER IF NOT EXISTS todo_fts_insert AFTER INSERT ON todo BEGIN
+    INSERT INTO todo_fts(rowid, title) VALUES (NEW.id, NEW.title);
+END;
+
+CREATE TRIGGER IF NOT EXISTS todo_fts_delete AFTER DELETE ON todo BEGIN
+    INSERT INTO todo_fts(todo_fts, rowid, title) VALUES ('delete', OLD.id, OLD.title);
+END;
+
+CREATE TRIGGER IF NOT EXISTS todo_fts_update AFTER UPDATE OF title ON todo BEGIN
+    INSERT INTO todo_fts(todo_fts, rowid, title) VALUES ('delete', OLD.id, OLD.title);
+    INSERT INTO todo_fts(rowid, title) VALUES (NEW.id, NEW.title);
+END;
@@ -0,0 +1,6 @@
+--Filename: migrations/sqlite/000002_todo_events.down.sql
+
+DROP TRIGGER IF EXISTS todo_events_delete;
+DROP TRIGGER IF EXISTS todo_events_update;
+DROP TRIGGER IF EXISTS todo_events_insert;
+DROP TABLE IF EXISTS todo_events;
@@ -0,0 +1,23 @@
+--Filename: migrations/sqlite/000002_todo_events.up.sql
+
+CREATE TABLE
+    IF NOT EXISTS todo_events(
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        op text NOT NULL,
+        todo_id INTEGER NOT NULL
+    );
+
+-- every change to the todo table is recorded as an event, there is no
+-- NOTIFY in SQLite so the API polls the table for new events
+CREATE TRIGGER IF NOT EXISTS todo_events_insert AFTER INSERT ON todo BEGIN
+    INSERT INTO todo_events(op, todo_id) VALUES ('created', NEW.id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS todo_events_update AFTER UPDATE ON todo BEGIN
+    INSERT INTO todo_events(op, todo_id) VALUES ('updated', NEW.id);
+END;
+
+CREATE TRIGGER IF NOT EXISTS todo_events_delete AFTER DELETE ON todo BEGIN
+    INSERT INTO todo_events(op, todo_id) VALUES ('deleted', OLD.id);
+END;
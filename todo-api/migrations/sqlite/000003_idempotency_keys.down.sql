@@ -0,0 +1,3 @@
+--Filename: migrations/sqlite/000003_idempotency_keys.down.sql
+
+DROP TABLE IF EXISTS idempotency_keys;
@@ -0,0 +1,15 @@
+--Filename: migrations/sqlite/000003_idempotency_keys.up.sql
+
+-- responses to requests sent with an Idempotency-Key header, status is zero
+-- while the original request is still being processed
+CREATE TABLE
+    IF NOT EXISTS idempotency_keys(
+        key text PRIMARY KEY,
+        created_at TIMESTAMP NOT NULL,
+        fingerprint text NOT NULL,
+        status INTEGER NOT NULL DEFAULT 0,
+        headers text NOT NULL DEFAULT '{}',
+        body BLOB
+    );
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys(created_at);
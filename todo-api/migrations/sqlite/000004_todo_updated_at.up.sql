@@ -0,0 +1,6 @@
+--Filename: migrations/sqlite/000004_todo_updated_at.up.sql
+
+-- SQLite can't add a column with a non-constant default, the model always sets it
+ALTER TABLE todo ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT '';
+
+UPDATE todo SET updated_at = created_at;
@@ -0,0 +1,5 @@
+--Filename: migrations/sqlite/000005_todo_completed_at.down.sql
+
+DROP INDEX IF EXISTS todo_completed_at_idx;
+DROP INDEX IF EXISTS todo_updated_at_idx;
+ALTER TABLE todo DROP COLUMN completed_at;
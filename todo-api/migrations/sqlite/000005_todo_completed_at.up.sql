@@ -0,0 +1,8 @@
+--Filename: migrations/sqlite/000005_todo_completed_at.up.sql
+
+ALTER TABLE todo ADD COLUMN completed_at TIMESTAMP;
+
+UPDATE todo SET completed_at = updated_at WHERE completed;
+
+CREATE INDEX IF NOT EXISTS todo_updated_at_idx ON todo(updated_at);
+CREATE INDEX IF NOT EXISTS todo_completed_at_idx ON todo(completed_at);
@@ -0,0 +1,4 @@
+--Filename: migrations/sqlite/000006_todo_public_id.down.sql
+
+DROP INDEX IF EXISTS todo_public_id_idx;
+ALTER TABLE todo DROP COLUMN public_id;
@@ -0,0 +1,5 @@
+--Filename: migrations/sqlite/000006_todo_public_id.up.sql
+
+ALTER TABLE todo ADD COLUMN public_id TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS todo_public_id_idx ON todo(public_id);
@@ -0,0 +1,20 @@
+--Filename: migrations/sqlite/000007_todo_ulid.down.sql
+
+-- the generated public ids are kept, they can't be told apart from client ones
+DROP TRIGGER IF EXISTS todo_events_insert;
+DROP TRIGGER IF EXISTS todo_events_update;
+DROP TRIGGER IF EXISTS todo_events_delete;
+
+CREATE TRIGGER todo_events_insert AFTER INSERT ON todo BEGIN
+    INSERT INTO todo_events(op, todo_id) VALUES ('created', NEW.id);
+END;
+
+CREATE TRIGGER todo_events_update AFTER UPDATE ON todo BEGIN
+    INSERT INTO todo_events(op, todo_id) VALUES ('updated', NEW.id);
+END;
+
+CREATE TRIGGER todo_events_delete AFTER DELETE ON todo BEGIN
+    INSERT INTO todo_events(op, todo_id) VALUES ('deleted', OLD.id);
+END;
+
+ALTER TABLE todo_events DROP COLUMN todo_public_id;
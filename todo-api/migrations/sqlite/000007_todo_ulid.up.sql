@@ -0,0 +1,42 @@
+--Filename: migrations/sqlite/000007_todo_ulid.up.sql
+
+-- the triggers are recreated below, the backfill isn't a change listeners
+-- need to hear about
+DROP TRIGGER IF EXISTS todo_events_insert;
+DROP TRIGGER IF EXISTS todo_events_update;
+DROP TRIGGER IF EXISTS todo_events_delete;
+
+-- every todo gets a ULID as its public id, tasks put under a client UUID keep
+-- it. The first 10 characters encode created_at in milliseconds, the other 16
+-- are random
+UPDATE todo SET public_id = (
+    WITH RECURSIVE ulid(i, s) AS (
+        SELECT 0, ''
+        UNION ALL
+        SELECT i + 1, s || substr('0123456789ABCDEFGHJKMNPQRSTVWXYZ',
+            CASE
+                WHEN i < 10 THEN ((CAST(strftime('%s', todo.created_at) AS INTEGER) * 1000) >> ((9 - i) * 5)) & 31
+                ELSE abs(random()) % 32
+            END + 1, 1)
+        FROM ulid WHERE i < 26
+    )
+    SELECT s FROM ulid WHERE i = 26
+)
+WHERE public_id IS NULL;
+
+-- events keep the public id, a deleted task can't be joined any more
+ALTER TABLE todo_events ADD COLUMN todo_public_id TEXT;
+
+UPDATE todo_events SET todo_public_id = (SELECT public_id FROM todo WHERE todo.id = todo_events.todo_id);
+
+CREATE TRIGGER todo_events_insert AFTER INSERT ON todo BEGIN
+    INSERT INTO todo_events(op, todo_id, todo_public_id) VALUES ('created', NEW.id, NEW.public_id);
+END;
+
+CREATE TRIGGER todo_events_update AFTER UPDATE ON todo BEGIN
+    INSERT INTO todo_events(op, todo_id, todo_public_id) VALUES ('updated', NEW.id, NEW.public_id);
+END;
+
+CREATE TRIGGER todo_events_delete AFTER DELETE ON todo BEGIN
+    INSERT INTO todo_events(op, todo_id, todo_public_id) VALUES ('deleted', OLD.id, OLD.public_id);
+END;
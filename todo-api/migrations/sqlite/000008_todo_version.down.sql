@@ -0,0 +1,3 @@
+--Filename: migrations/sqlite/000008_todo_version.down.sql
+
+ALTER TABLE todo DROP COLUMN version;
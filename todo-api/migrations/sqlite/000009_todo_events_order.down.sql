@@ -0,0 +1,3 @@
+--Filename: migrations/sqlite/000009_todo_events_order.down.sql
+
+-- nothing to undo, see the up migration
@@ -0,0 +1,5 @@
+--Filename: migrations/sqlite/000009_todo_events_order.up.sql
+
+-- nothing to change: event ids are cursors for streams and sync clients and
+-- must become visible in order. SQLite has one writer at a time that holds
+-- the write lock until it commits, so its event ids already do
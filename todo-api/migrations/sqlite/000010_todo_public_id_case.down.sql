@@ -0,0 +1,5 @@
+--Filename: migrations/sqlite/000010_todo_public_id_case.down.sql
+
+DROP INDEX IF EXISTS todo_public_id_lower_idx;
+
+CREATE UNIQUE INDEX IF NOT EXISTS todo_public_id_idx ON todo(public_id);
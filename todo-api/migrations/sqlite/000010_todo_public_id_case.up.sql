@@ -0,0 +1,8 @@
+--Filename: migrations/sqlite/000010_todo_public_id_case.up.sql
+
+-- UUIDs and ULIDs don't depend on case. Public ids are kept as the client
+-- wrote them, CalDAV clients expect their resource under the very name they
+-- chose, and are looked up and kept unique regardless of case
+DROP INDEX IF EXISTS todo_public_id_idx;
+
+CREATE UNIQUE INDEX IF NOT EXISTS todo_public_id_lower_idx ON todo(lower(public_id));
@@ -0,0 +1,97 @@
+//Filename: pkg/client/client.go
+
+// Package client is a typed Go client for the /v1 todo API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client sends requests to a todo API, the zero value is not usable, use New()
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient() replaces the default http.Client, which times out after 10 seconds
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// New() returns a client for the API at baseURL, e.g. http://localhost:4000
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// do() sends a request with an optional JSON body and decodes the envelope
+// of a successful response into dst. It returns the headers of the response
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, body interface{}, dst interface{}) (http.Header, error) {
+	var reqBody io.Reader
+
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(js)
+
+		header = header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		header.Set("Content-Type", "application/json")
+	}
+
+	return c.send(ctx, method, path, header, reqBody, dst)
+}
+
+// send() sends a request with the headers and body and decodes the envelope
+// of a successful response into dst. It returns the headers of the response
+func (c *Client) send(ctx context.Context, method, path string, header http.Header, body io.Reader, dst interface{}) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return nil, decodeError(res)
+	}
+
+	if dst == nil {
+		return res.Header, nil
+	}
+
+	return res.Header, json.NewDecoder(res.Body).Decode(dst)
+}
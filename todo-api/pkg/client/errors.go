@@ -0,0 +1,123 @@
+//Filename: pkg/client/errors.go
+
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// errors matched with errors.Is() against the *Error of a failed request
+var (
+	ErrNotFound     = errors.New("client: record not found")
+	ErrEditConflict = errors.New("client: edit conflict")
+	ErrRateLimited  = errors.New("client: rate limit exceeded")
+	// ErrPreconditionFailed means the todo task didn't match If-Match or If-None-Match
+	ErrPreconditionFailed = errors.New("client: precondition failed")
+)
+
+// Error is an error response of the API
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("client: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is() lets errors.Is() match the sentinel errors by status code
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrEditConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrPreconditionFailed:
+		return e.StatusCode == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+// ValidationError is a 422 response, Fields maps each invalid field to its message
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	keys := make([]string, 0, len(e.Fields))
+	for key := range e.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := make([]string, 0, len(keys))
+	for _, key := range keys {
+		problems = append(problems, key+": "+e.Fields[key])
+	}
+
+	return "client: validation failed: " + strings.Join(problems, ", ")
+}
+
+// RowError lists the problems with one row of an import, Row is the line of a
+// CSV file or the position in a JSON array or calendar
+type RowError struct {
+	Row    int               `json:"row"`
+	Errors map[string]string `json:"errors"`
+}
+
+// ImportError is a 422 response to an import with invalid rows, nothing was imported
+type ImportError struct {
+	Rows []RowError
+}
+
+func (e *ImportError) Error() string {
+	problems := make([]string, 0, len(e.Rows))
+	for _, row := range e.Rows {
+		failed := ValidationError{Fields: row.Errors}
+		problems = append(problems, fmt.Sprintf("row %d: %s", row.Row, strings.TrimPrefix(failed.Error(), "client: validation failed: ")))
+	}
+
+	return "client: import failed: " + strings.Join(problems, "; ")
+}
+
+// decodeError() reads the {"error": ...} envelope of a failed response, the
+// message is a map of fields for failed validation and a string otherwise
+func decodeError(res *http.Response) error {
+	var body struct {
+		Error json.RawMessage `json:"error"`
+	}
+
+	js, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil || json.Unmarshal(js, &body) != nil || body.Error == nil {
+		return &Error{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(js))}
+	}
+
+	if res.StatusCode == http.StatusUnprocessableEntity {
+		var fields map[string]string
+		if json.Unmarshal(body.Error, &fields) == nil {
+			return &ValidationError{Fields: fields}
+		}
+
+		var rows struct {
+			Rows []RowError `json:"rows"`
+		}
+		if json.Unmarshal(body.Error, &rows) == nil && rows.Rows != nil {
+			return &ImportError{Rows: rows.Rows}
+		}
+	}
+
+	var message string
+	if json.Unmarshal(body.Error, &message) != nil {
+		message = string(body.Error)
+	}
+
+	return &Error{StatusCode: res.StatusCode, Message: message}
+}
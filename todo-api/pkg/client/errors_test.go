@@ -0,0 +1,120 @@
+//Filename: pkg/client/errors_test.go
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestClient() returns a client for a server that answers every request
+// with status and body
+func newTestClient(t *testing.T, status int, body string) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return New(srv.URL)
+}
+
+func TestErrorSentinels(t *testing.T) {
+	sentinels := []error{ErrNotFound, ErrEditConflict, ErrRateLimited, ErrPreconditionFailed}
+
+	tests := []struct {
+		name   string
+		status int
+		want   error
+	}{
+		{"not found", http.StatusNotFound, ErrNotFound},
+		{"edit conflict", http.StatusConflict, ErrEditConflict},
+		{"rate limited", http.StatusTooManyRequests, ErrRateLimited},
+		{"precondition failed", http.StatusPreconditionFailed, ErrPreconditionFailed},
+		{"server error", http.StatusInternalServerError, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, tt.status, `{"error": "something went wrong"}`)
+
+			_, err := c.GetTodo(context.Background(), "01J9Z3V5G7Q8R2M4N6P8T0W2Y4")
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want an *Error", err)
+			}
+			if apiErr.StatusCode != tt.status || apiErr.Message != "something went wrong" {
+				t.Errorf("got %+v, want status %d and the message of the envelope", apiErr, tt.status)
+			}
+
+			for _, sentinel := range sentinels {
+				if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+					t.Errorf("errors.Is(err, %v) = %v", sentinel, got)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorWithoutEnvelope(t *testing.T) {
+	c := newTestClient(t, http.StatusBadGateway, "upstream unavailable\n")
+
+	_, err := c.GetTodo(context.Background(), "01J9Z3V5G7Q8R2M4N6P8T0W2Y4")
+
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %v, want an *Error", err)
+	}
+	if apiErr.StatusCode != http.StatusBadGateway || apiErr.Message != "upstream unavailable" {
+		t.Errorf("got %+v, want the body as the message", apiErr)
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	c := newTestClient(t, http.StatusUnprocessableEntity, `{"error": {"title": "must be provided", "description": "must not be more than 1000 bytes long"}}`)
+
+	_, err := c.CreateTodo(context.Background(), NewTodo{})
+
+	var failed *ValidationError
+	if !errors.As(err, &failed) {
+		t.Fatalf("got %v, want a *ValidationError", err)
+	}
+
+	if len(failed.Fields) != 2 || failed.Fields["title"] != "must be provided" {
+		t.Errorf("got fields %v", failed.Fields)
+	}
+
+	want := "client: validation failed: description: must not be more than 1000 bytes long, title: must be provided"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
+
+func TestImportError(t *testing.T) {
+	c := newTestClient(t, http.StatusUnprocessableEntity, `{"error": {"rows": [{"row": 2, "errors": {"title": "must be provided"}}, {"row": 5, "errors": {"completed": "must be true or false"}}]}}`)
+
+	_, err := c.ImportTodos(context.Background(), FormatJSON, "todos.json", strings.NewReader("[]"), false)
+
+	var rejected *ImportError
+	if !errors.As(err, &rejected) {
+		t.Fatalf("got %v, want an *ImportError", err)
+	}
+
+	if len(rejected.Rows) != 2 || rejected.Rows[0].Row != 2 || rejected.Rows[1].Errors["completed"] != "must be true or false" {
+		t.Errorf("got rows %+v", rejected.Rows)
+	}
+
+	want := "client: import failed: row 2: title: must be provided; row 5: completed: must be true or false"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+}
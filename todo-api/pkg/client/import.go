@@ -0,0 +1,73 @@
+//Filename: pkg/client/import.go
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+// import formats accepted by ImportTodos(), an empty format lets the API detect it
+const (
+	FormatCSV     = "csv"
+	FormatJSON    = "json"
+	FormatTodoist = "todoist"
+	FormatTrello  = "trello"
+	FormatICS     = "ics"
+)
+
+// ImportResult describes the tasks an import created, or would create for a
+// dry run, in which case they have no ids or timestamps
+type ImportResult struct {
+	DryRun bool    `json:"dry_run"`
+	Count  int     `json:"count"`
+	Todos  []*Todo `json:"todos"`
+}
+
+// ImportTodos() uploads a file of todo tasks. Either every task is created or,
+// when a row is invalid, none are and the error is an *ImportError
+func (c *Client) ImportTodos(ctx context.Context, format, filename string, file io.Reader, dryRun bool) (*ImportResult, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	if format != "" {
+		err := mw.WriteField("format", format)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(part, file)
+	if err != nil {
+		return nil, err
+	}
+
+	err = mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	path := "/v1/todos/import"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+
+	var result ImportResult
+
+	header := http.Header{"Content-Type": {mw.FormDataContentType()}}
+
+	_, err = c.send(ctx, http.MethodPost, path, header, &body, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,269 @@
+//Filename: pkg/client/todos.go
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Todo is a todo task as returned by the API, ID is a ULID or the UUID the
+// task was put under
+type Todo struct {
+	ID          string     `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Completed   bool       `json:"completed"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	Version     int32      `json:"version"`
+	// ETag is the entity tag of the response the task came in, for TodoUpdate.IfMatch
+	ETag string `json:"-"`
+}
+
+// NewTodo holds the fields of a todo task to create
+type NewTodo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Completed   bool   `json:"completed"`
+}
+
+// TodoUpdate holds the fields to change, nil fields are left as they are. With
+// IfMatch set to the ETag of a Todo the update fails with ErrPreconditionFailed
+// when somebody else changed the task since
+type TodoUpdate struct {
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Completed   *bool   `json:"completed,omitempty"`
+	IfMatch     string  `json:"-"`
+}
+
+// Filters selects and orders the todo tasks returned by ListTodos(), zero
+// values are left to the API defaults
+type Filters struct {
+	Title           string
+	UpdatedSince    time.Time
+	UpdatedBefore   time.Time
+	CompletedSince  time.Time
+	CompletedBefore time.Time
+	Sort            string // id, title, completed, created_at, updated_at, completed_at, prefix with - for descending
+	Page            int
+	PageSize        int
+}
+
+// Metadata describes the page returned by ListTodos(), it is empty when nothing matched
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records,omitempty"`
+}
+
+// String() and Bool() return pointers for the fields of a TodoUpdate
+func String(s string) *string { return &s }
+func Bool(b bool) *bool       { return &b }
+
+// CreateTodo() creates a todo task
+func (c *Client) CreateTodo(ctx context.Context, input NewTodo) (*Todo, error) {
+	var env struct {
+		Todo *Todo `json:"todo"`
+	}
+
+	header, err := c.do(ctx, http.MethodPost, "/v1/todos", nil, input, &env)
+	if err != nil {
+		return nil, err
+	}
+
+	env.Todo.ETag = header.Get("ETag")
+
+	return env.Todo, nil
+}
+
+// GetTodo() fetches a todo task by id
+func (c *Client) GetTodo(ctx context.Context, id string) (*Todo, error) {
+	var env struct {
+		Todo *Todo `json:"todo"`
+	}
+
+	header, err := c.do(ctx, http.MethodGet, "/v1/todos/"+url.PathEscape(id), nil, nil, &env)
+	if err != nil {
+		return nil, err
+	}
+
+	env.Todo.ETag = header.Get("ETag")
+
+	return env.Todo, nil
+}
+
+// PutTodo() replaces every field of a todo task, a task that doesn't exist yet
+// is created when id is a UUID
+func (c *Client) PutTodo(ctx context.Context, id string, input NewTodo) (*Todo, error) {
+	var env struct {
+		Todo *Todo `json:"todo"`
+	}
+
+	header, err := c.do(ctx, http.MethodPut, "/v1/todos/"+url.PathEscape(id), nil, input, &env)
+	if err != nil {
+		return nil, err
+	}
+
+	env.Todo.ETag = header.Get("ETag")
+
+	return env.Todo, nil
+}
+
+// UpdateTodo() changes the non-nil fields of a todo task
+func (c *Client) UpdateTodo(ctx context.Context, id string, input TodoUpdate) (*Todo, error) {
+	var env struct {
+		Todo *Todo `json:"todo"`
+	}
+
+	var header http.Header
+	if input.IfMatch != "" {
+		header = http.Header{"If-Match": {input.IfMatch}}
+	}
+
+	header, err := c.do(ctx, http.MethodPatch, "/v1/todos/"+url.PathEscape(id), header, input, &env)
+	if err != nil {
+		return nil, err
+	}
+
+	env.Todo.ETag = header.Get("ETag")
+
+	return env.Todo, nil
+}
+
+// DeleteTodo() deletes a todo task
+func (c *Client) DeleteTodo(ctx context.Context, id string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/v1/todos/"+url.PathEscape(id), nil, nil, nil)
+	return err
+}
+
+// DeleteTodoIfMatch() deletes a todo task only while it has the ETag of a Todo,
+// it fails with ErrPreconditionFailed when somebody else changed the task since
+func (c *Client) DeleteTodoIfMatch(ctx context.Context, id, etag string) error {
+	header := http.Header{"If-Match": {etag}}
+
+	_, err := c.do(ctx, http.MethodDelete, "/v1/todos/"+url.PathEscape(id), header, nil, nil)
+	return err
+}
+
+// ListTodos() fetches one page of todo tasks
+func (c *Client) ListTodos(ctx context.Context, filters Filters) ([]*Todo, Metadata, error) {
+	var env struct {
+		Todos    []*Todo  `json:"todos"`
+		Metadata Metadata `json:"metadata"`
+	}
+
+	_, err := c.do(ctx, http.MethodGet, "/v1/todos?"+filters.query().Encode(), nil, nil, &env)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return env.Todos, env.Metadata, nil
+}
+
+// query() encodes the filters as the query string of the list endpoint
+func (f Filters) query() url.Values {
+	qs := url.Values{}
+
+	if f.Title != "" {
+		qs.Set("title", f.Title)
+	}
+
+	times := []struct {
+		key string
+		t   time.Time
+	}{
+		{"updated_since", f.UpdatedSince},
+		{"updated_before", f.UpdatedBefore},
+		{"completed_since", f.CompletedSince},
+		{"completed_before", f.CompletedBefore},
+	}
+
+	for _, param := range times {
+		if !param.t.IsZero() {
+			qs.Set(param.key, param.t.Format(time.RFC3339Nano))
+		}
+	}
+
+	if f.Sort != "" {
+		qs.Set("sort", f.Sort)
+	}
+	if f.Page > 0 {
+		qs.Set("page", strconv.Itoa(f.Page))
+	}
+	if f.PageSize > 0 {
+		qs.Set("page_size", strconv.Itoa(f.PageSize))
+	}
+
+	return qs
+}
+
+// TodoIterator walks every todo task matching the filters, one page at a time:
+//
+//	it := c.Todos(client.Filters{Sort: "id"})
+//	for it.Next(ctx) {
+//		todo := it.Todo()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type TodoIterator struct {
+	client  *Client
+	filters Filters
+	page    []*Todo
+	current *Todo
+	done    bool
+	err     error
+}
+
+// Todos() returns an iterator over the todo tasks matching filters, starting
+// at filters.Page
+func (c *Client) Todos(filters Filters) *TodoIterator {
+	if filters.Page < 1 {
+		filters.Page = 1
+	}
+
+	return &TodoIterator{client: c, filters: filters}
+}
+
+// Next() advances to the next todo task, fetching the next page when needed.
+// It returns false at the end or on an error, check Err() afterwards
+func (it *TodoIterator) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			it.current = nil
+			return false
+		}
+
+		todos, metadata, err := it.client.ListTodos(ctx, it.filters)
+		if err != nil {
+			it.err = err
+			continue
+		}
+
+		it.page = todos
+		it.done = it.filters.Page >= metadata.LastPage
+		it.filters.Page++
+	}
+
+	it.current, it.page = it.page[0], it.page[1:]
+
+	return true
+}
+
+// Todo() returns the todo task Next() advanced to
+func (it *TodoIterator) Todo() *Todo {
+	return it.current
+}
+
+// Err() returns the error that stopped the iteration, if any
+func (it *TodoIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,117 @@
+//Filename: pkg/client/todos_test.go
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// newListServer() serves count todo tasks from the list endpoint, paged like the
+// API does, and counts the pages it was asked for
+func newListServer(t *testing.T, count int, requests *int) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+
+		todos := []*Todo{}
+		for i := (page - 1) * pageSize; i < page*pageSize && i < count; i++ {
+			todos = append(todos, &Todo{ID: strconv.Itoa(i + 1), Title: "task " + strconv.Itoa(i+1)})
+		}
+
+		//the API sends empty metadata when nothing matched
+		metadata := Metadata{}
+		if count > 0 {
+			metadata = Metadata{
+				CurrentPage:  page,
+				PageSize:     pageSize,
+				FirstPage:    1,
+				LastPage:     (count + pageSize - 1) / pageSize,
+				TotalRecords: count,
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"todos": todos, "metadata": metadata})
+	}))
+
+	t.Cleanup(srv.Close)
+
+	return New(srv.URL)
+}
+
+func TestTodoIterator(t *testing.T) {
+	tests := []struct {
+		name     string
+		count    int
+		pageSize int
+		pages    int
+	}{
+		{"several pages", 5, 2, 3},
+		{"full last page", 4, 2, 2},
+		{"one page", 3, 10, 1},
+		{"nothing matched", 0, 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			c := newListServer(t, tt.count, &requests)
+
+			it := c.Todos(Filters{PageSize: tt.pageSize})
+
+			ids := []string{}
+			for it.Next(context.Background()) {
+				ids = append(ids, it.Todo().ID)
+			}
+
+			if err := it.Err(); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(ids) != tt.count {
+				t.Fatalf("got %q, want %d tasks", ids, tt.count)
+			}
+			for i, id := range ids {
+				if id != strconv.Itoa(i+1) {
+					t.Fatalf("got %q, want the tasks in order", ids)
+				}
+			}
+
+			if requests != tt.pages {
+				t.Errorf("fetched %d pages, want %d", requests, tt.pages)
+			}
+
+			//the iterator stays at the end
+			if it.Next(context.Background()) || it.Todo() != nil {
+				t.Error("Next() advanced past the end")
+			}
+			if requests != tt.pages {
+				t.Errorf("fetched another page after the end")
+			}
+		})
+	}
+}
+
+func TestTodoIteratorStopsOnError(t *testing.T) {
+	c := newTestClient(t, http.StatusTooManyRequests, `{"error": "rate limit exceeded"}`)
+
+	it := c.Todos(Filters{})
+
+	if it.Next(context.Background()) {
+		t.Fatal("Next() advanced on an error")
+	}
+
+	if err := it.Err(); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("got %v, want ErrRateLimited", err)
+	}
+}